@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"database/sql"
+
+	"local-event-backend/utils"
+)
+
+// RecordLoginAttempt writes one row to the login_attempts audit table.
+// userID is 0 when the email did not match an account.
+func RecordLoginAttempt(email string, userID int, ip, userAgent string, success bool, reason string) error {
+	var uid sql.NullInt64
+	if userID > 0 {
+		uid = sql.NullInt64{Int64: int64(userID), Valid: true}
+	}
+	_, err := utils.DB.Exec(`
+		INSERT INTO login_attempts (email, user_id, ip_address, user_agent, success, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		email, uid, ip, userAgent, success, reason,
+	)
+	return err
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+
+	"local-event-backend/utils"
+)
+
+// ErrInvalidFeedToken means the calendar feed token is unknown or was
+// replaced by a newer one.
+var ErrInvalidFeedToken = errors.New("invalid calendar feed token")
+
+// NewCalendarFeedToken issues userID's calendar feed token and returns it.
+// Each user has one; issuing a new one retires the old, so a leaked feed
+// URL can be revoked. Only its hash is kept in calendar_feed_tokens.
+func NewCalendarFeedToken(userID int) (string, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = utils.DB.Exec(`
+		INSERT INTO calendar_feed_tokens (user_id, token_hash) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET token_hash = EXCLUDED.token_hash, created_at = NOW(), last_used_at = NULL`,
+		userID, hashToken(token),
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// CalendarFeedUser returns the user a feed token belongs to.
+func CalendarFeedUser(token string) (int, error) {
+	var userID int
+	err := utils.DB.QueryRow(`
+		UPDATE calendar_feed_tokens t SET last_used_at = NOW()
+		FROM users u
+		WHERE t.token_hash = $1 AND u.id = t.user_id AND u.deleted_at IS NULL
+		RETURNING t.user_id`,
+		hashToken(token),
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidFeedToken
+	}
+	return userID, err
+}
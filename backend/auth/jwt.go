@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/golang-jwt/jwt/v5"
+	"local-event-backend/utils"
+)
+
+// ParseToken fully verifies a JWT issued by utils.GenerateToken: signing
+// algorithm and kid, signature, exp, nbf, issuer and audience.
+func ParseToken(tokenStr string) (Principal, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(utils.Keys.Algorithms()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(utils.JwtIssuer()),
+		jwt.WithAudience(utils.JwtAudience()),
+	)
+
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(tokenStr, claims, keyFunc)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+
+	hasura, ok := claims[HasuraClaimsKey].(map[string]interface{})
+	if !ok {
+		return Principal{}, fmt.Errorf("%w: missing Hasura claims", ErrUnauthenticated)
+	}
+	userIDStr, _ := hasura["x-hasura-user-id"].(string)
+	role, _ := hasura["x-hasura-default-role"].(string)
+	principal, err := newPrincipal(userIDStr, role)
+	if err != nil {
+		return Principal{}, err
+	}
+	if allowed, ok := hasura["x-hasura-allowed-roles"].([]interface{}); ok {
+		for _, r := range allowed {
+			if role, ok := r.(string); ok {
+				principal.AllowedRoles = append(principal.AllowedRoles, role)
+			}
+		}
+	}
+	if !principal.CanAssume(principal.Role) {
+		return Principal{}, fmt.Errorf("%w: default role not allowed", ErrUnauthenticated)
+	}
+	principal.SessionID, _ = claims["sid"].(string)
+	if principal.SessionID == "" {
+		return Principal{}, fmt.Errorf("%w: missing session id", ErrUnauthenticated)
+	}
+	return principal, nil
+}
+
+// keyFunc picks the verification key named by the token's kid header.
+func keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := utils.Keys.Lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("key %q does not sign with %s", kid, token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+func newPrincipal(userIDStr, role string) (Principal, error) {
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil || userID <= 0 {
+		return Principal{}, fmt.Errorf("%w: invalid user id %q", ErrUnauthenticated, userIDStr)
+	}
+	if role == "" {
+		return Principal{}, fmt.Errorf("%w: missing role", ErrUnauthenticated)
+	}
+	return Principal{UserID: userID, Role: role}, nil
+}
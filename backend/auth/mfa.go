@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"local-event-backend/utils"
+)
+
+const (
+	totpIssuer      = "Minab"
+	backupCodeCount = 10
+	// backupCodeAlphabet avoids characters that are easy to misread.
+	backupCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+var (
+	// ErrInvalidMFACode means neither the TOTP code nor a backup code
+	// matched, or the TOTP code was already used.
+	ErrInvalidMFACode = errors.New("invalid two-factor code")
+	// ErrMFANotEnrolled means there is no pending or active TOTP secret.
+	ErrMFANotEnrolled = errors.New("two-factor authentication is not set up")
+	// ErrMFAAlreadyEnabled means TOTP is active; it has to be disabled,
+	// which needs the password and a code, before a new secret is set up.
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+)
+
+// MFAEnabled reports whether userID has activated TOTP.
+func MFAEnabled(userID int) (bool, error) {
+	var enabled bool
+	err := utils.DB.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM user_mfa WHERE user_id = $1 AND enabled_at IS NOT NULL)`,
+		userID,
+	).Scan(&enabled)
+	return enabled, err
+}
+
+// BeginTOTPEnrollment stores a new, not yet active secret for userID and
+// returns it with its provisioning URI. Any previous pending secret is
+// replaced. It fails with ErrMFAAlreadyEnabled while TOTP is active, so a
+// session alone cannot swap out the account's authenticator.
+func BeginTOTPEnrollment(userID int, account string) (string, string, error) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	res, err := utils.DB.Exec(`
+		INSERT INTO user_mfa (user_id, pending_secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET pending_secret = EXCLUDED.pending_secret
+		WHERE user_mfa.enabled_at IS NULL`,
+		userID, secret,
+	)
+	if err != nil {
+		return "", "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+	return secret, TOTPProvisioningURI(totpIssuer, account, secret), nil
+}
+
+// ActivateTOTP confirms the pending secret with a code from the app, makes
+// it the active secret and returns a fresh set of backup codes. They are
+// only ever shown this once.
+func ActivateTOTP(userID int, code string) ([]string, error) {
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var pending sql.NullString
+	var enabledAt sql.NullTime
+	err = tx.QueryRow(`SELECT pending_secret, enabled_at FROM user_mfa WHERE user_id = $1 FOR UPDATE`, userID).
+		Scan(&pending, &enabledAt)
+	if err == nil && enabledAt.Valid {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if err == sql.ErrNoRows || (err == nil && !pending.Valid) {
+		return nil, ErrMFANotEnrolled
+	} else if err != nil {
+		return nil, err
+	}
+
+	step, ok := matchTOTP(pending.String, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+	_, err = tx.Exec(`
+		UPDATE user_mfa
+		SET totp_secret = pending_secret, pending_secret = NULL, enabled_at = NOW(), last_used_step = $2
+		WHERE user_id = $1`,
+		userID, step,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceBackupCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// DisableTOTP removes the secret and backup codes of userID.
+func DisableTOTP(userID int) error {
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_backup_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM user_mfa WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// VerifyMFA accepts either a current TOTP code or an unused backup code. A
+// TOTP code is only accepted once, so an observed code cannot be replayed.
+func VerifyMFA(userID int, code string) error {
+	code = strings.TrimSpace(code)
+
+	var secret sql.NullString
+	err := utils.DB.QueryRow(
+		`SELECT totp_secret FROM user_mfa WHERE user_id = $1 AND enabled_at IS NOT NULL`,
+		userID,
+	).Scan(&secret)
+	if err == sql.ErrNoRows || (err == nil && !secret.Valid) {
+		return ErrMFANotEnrolled
+	} else if err != nil {
+		return err
+	}
+
+	if step, ok := matchTOTP(secret.String, code, time.Now()); ok {
+		res, err := utils.DB.Exec(
+			`UPDATE user_mfa SET last_used_step = $2 WHERE user_id = $1 AND (last_used_step IS NULL OR last_used_step < $2)`,
+			userID, step,
+		)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			return nil
+		}
+		return ErrInvalidMFACode
+	}
+
+	res, err := utils.DB.Exec(
+		`UPDATE user_backup_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, hashToken(normalizeBackupCode(code)),
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return nil
+	}
+	return ErrInvalidMFACode
+}
+
+func replaceBackupCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM user_backup_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, backupCodeCount)
+	for i := 0; i < backupCodeCount; i++ {
+		code, err := newBackupCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(
+			`INSERT INTO user_backup_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userID, hashToken(normalizeBackupCode(code)),
+		)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// newBackupCode returns a code like "k7pq-x2mv-r9" (50 bits of entropy).
+func newBackupCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for i, v := range buf {
+		if i == 4 || i == 8 {
+			b.WriteByte('-')
+		}
+		b.WriteByte(backupCodeAlphabet[int(v)%len(backupCodeAlphabet)])
+	}
+	return b.String(), nil
+}
+
+func normalizeBackupCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
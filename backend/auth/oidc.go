@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCProvider is an OpenID Connect identity provider configured from the
+// environment. Endpoints left empty are filled in from the issuer's
+// discovery document the first time they are needed, so a local mock server
+// can be used by setting them explicitly.
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	AuthURL      string
+	TokenURL     string
+	JWKSURL      string
+	Scopes       []string
+	HTTPClient   *http.Client
+
+	mu         sync.Mutex
+	discovered bool
+	keys       map[string]interface{}
+}
+
+// OIDCIdentity is what a verified ID token says about the user.
+type OIDCIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+var oidcProviders = map[string]*OIDCProvider{}
+
+// wellKnownIssuers lets common providers be enabled with just client
+// credentials.
+var wellKnownIssuers = map[string]string{
+	"google": "https://accounts.google.com",
+}
+
+// LoadOIDCProviders reads OIDC_PROVIDERS, a comma-separated list of names,
+// and for each NAME the variables OIDC_NAME_ISSUER, OIDC_NAME_CLIENT_ID,
+// OIDC_NAME_CLIENT_SECRET, OIDC_NAME_REDIRECT_URL and optionally
+// OIDC_NAME_AUTH_URL, OIDC_NAME_TOKEN_URL, OIDC_NAME_JWKS_URL and
+// OIDC_NAME_SCOPES.
+func LoadOIDCProviders() error {
+	providers := map[string]*OIDCProvider{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		env := func(key string) string {
+			return os.Getenv("OIDC_" + strings.ToUpper(name) + "_" + key)
+		}
+
+		p := &OIDCProvider{
+			Name:         name,
+			Issuer:       env("ISSUER"),
+			ClientID:     env("CLIENT_ID"),
+			ClientSecret: env("CLIENT_SECRET"),
+			RedirectURL:  env("REDIRECT_URL"),
+			AuthURL:      env("AUTH_URL"),
+			TokenURL:     env("TOKEN_URL"),
+			JWKSURL:      env("JWKS_URL"),
+			Scopes:       strings.Fields(env("SCOPES")),
+			HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+		}
+		if p.Issuer == "" {
+			p.Issuer = wellKnownIssuers[name]
+		}
+		if p.Issuer == "" || p.ClientID == "" {
+			return fmt.Errorf("OIDC provider %q needs an issuer and a client id", name)
+		}
+		if p.RedirectURL == "" {
+			p.RedirectURL = "http://localhost:8082/auth/oidc/callback"
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "email", "profile"}
+		}
+		providers[name] = p
+	}
+	oidcProviders = providers
+	return nil
+}
+
+// OIDCProviderByName returns the configured provider called name.
+func OIDCProviderByName(name string) (*OIDCProvider, bool) {
+	p, ok := oidcProviders[strings.ToLower(name)]
+	return p, ok
+}
+
+// AuthCodeURL is where the browser is sent to log in. verifier is the PKCE
+// code verifier; only its S256 challenge leaves the server here.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURL)
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + q.Encode(), nil
+}
+
+// Exchange trades the authorization code for tokens and returns the raw ID
+// token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", verifier)
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oidc token request: %w", err)
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("oidc token response: %w", err)
+	}
+	if res.StatusCode >= 300 || body.Error != "" {
+		return "", fmt.Errorf("oidc token exchange failed: %s %s %s", res.Status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("oidc token response has no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// VerifyIDToken checks the ID token's signature against the provider's
+// JWKS, its issuer, audience, expiry and that it carries nonce.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, raw, nonce string) (OIDCIdentity, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithLeeway(time.Minute),
+	)
+
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.publicKey(ctx, kid)
+	})
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("%w: id token: %v", ErrUnauthenticated, err)
+	}
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return OIDCIdentity{}, fmt.Errorf("%w: id token nonce mismatch", ErrUnauthenticated)
+	}
+
+	id := OIDCIdentity{}
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims["email"].(string)
+	id.Name, _ = claims["name"].(string)
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		id.EmailVerified = v
+	case string:
+		id.EmailVerified = v == "true"
+	}
+	if id.Subject == "" {
+		return OIDCIdentity{}, fmt.Errorf("%w: id token has no subject", ErrUnauthenticated)
+	}
+	return id, nil
+}
+
+// discover fills endpoints not set in configuration from the issuer's
+// /.well-known/openid-configuration.
+func (p *OIDCProvider) discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovered || (p.AuthURL != "" && p.TokenURL != "" && p.JWKSURL != "") {
+		return nil
+	}
+
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", &doc); err != nil {
+		return fmt.Errorf("oidc discovery for %s: %w", p.Name, err)
+	}
+	if doc.Issuer != p.Issuer {
+		return fmt.Errorf("oidc discovery for %s: issuer %q does not match %q", p.Name, doc.Issuer, p.Issuer)
+	}
+	if p.AuthURL == "" {
+		p.AuthURL = doc.AuthorizationEndpoint
+	}
+	if p.TokenURL == "" {
+		p.TokenURL = doc.TokenEndpoint
+	}
+	if p.JWKSURL == "" {
+		p.JWKSURL = doc.JWKSURI
+	}
+	p.discovered = true
+	return nil
+}
+
+// publicKey returns the provider key named kid, refetching the JWKS once when
+// the key is unknown so provider rotations are picked up.
+func (p *OIDCProvider) publicKey(ctx context.Context, kid string) (interface{}, error) {
+	if err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var set jwkSet
+	if err := p.getJSON(ctx, p.JWKSURL, &set); err != nil {
+		return nil, fmt.Errorf("fetch jwks for %s: %w", p.Name, err)
+	}
+	p.keys = set.publicKeys()
+
+	key, ok := p.keys[kid]
+	if !ok && kid == "" && len(p.keys) == 1 {
+		for _, only := range p.keys {
+			return only, nil
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown %s key id %q", p.Name, kid)
+	}
+	return key, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, u string, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("GET %s: %s", u, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(dst)
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"local-event-backend/utils"
+)
+
+var (
+	// ErrOIDCNoEmail means the provider did not share an email address.
+	ErrOIDCNoEmail = errors.New("identity provider returned no email")
+	// ErrOIDCEmailUnverified means an account with the provider's email
+	// exists but the provider has not verified that email, so linking it
+	// could hand the account to someone else.
+	ErrOIDCEmailUnverified = errors.New("identity provider email is not verified")
+)
+
+// LinkOIDCIdentity returns the user behind id at provider. A known identity
+// maps straight to its user; otherwise an existing account with the same
+// verified email is linked, or a new account is created.
+func LinkOIDCIdentity(provider string, id OIDCIdentity) (int, error) {
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(
+		`SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2`,
+		provider, id.Subject,
+	).Scan(&userID)
+	if err == nil {
+		return userID, tx.Commit()
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	email := strings.ToLower(strings.TrimSpace(id.Email))
+	if email == "" {
+		return 0, ErrOIDCNoEmail
+	}
+
+	err = tx.QueryRow(`SELECT id FROM users WHERE lower(email) = $1 FOR UPDATE`, email).Scan(&userID)
+	switch {
+	case err == sql.ErrNoRows:
+		userID, err = createOIDCUser(tx, email, id)
+		if err != nil {
+			return 0, err
+		}
+	case err != nil:
+		return 0, err
+	case !id.EmailVerified:
+		return 0, ErrOIDCEmailUnverified
+	default:
+		if _, err := tx.Exec(`UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()) WHERE id = $1`, userID); err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO user_identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4)`,
+		provider, id.Subject, userID, email,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+// createOIDCUser inserts a user who logs in through a provider. The password
+// is a random hash nobody knows, so has_password is false until a reset link
+// sets a real one.
+func createOIDCUser(tx *sql.Tx, email string, id OIDCIdentity) (int, error) {
+	random, err := newOpaqueToken()
+	if err != nil {
+		return 0, err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(random), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	var userID int
+	err = tx.QueryRow(`
+		INSERT INTO users (email, password, has_password, name, email_verified_at)
+		VALUES ($1, $2, false, NULLIF($3, ''), CASE WHEN $4 THEN NOW() END)
+		RETURNING id`,
+		email, string(hashed), strings.TrimSpace(id.Name), id.EmailVerified,
+	).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+	if err := GrantRole(tx, userID, RoleAttendee); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+
+	"local-event-backend/utils"
+)
+
+// Roles a user can hold inside an organization, stored in
+// organization_members. They are separate from the Hasura roles above.
+const (
+	OrgRoleOwner     = "owner"
+	OrgRoleManager   = "manager"
+	OrgRoleDoorStaff = "door-staff"
+)
+
+// Permission is something a member may do for an organization's events.
+type Permission string
+
+const (
+	// PermManageEvents covers creating, editing and cancelling events.
+	PermManageEvents Permission = "manage-events"
+	// PermCheckIn covers scanning tickets at the door.
+	PermCheckIn Permission = "check-in"
+	// PermManageMembers covers adding, removing and re-roling members.
+	PermManageMembers Permission = "manage-members"
+)
+
+var orgPermissions = map[string][]Permission{
+	OrgRoleOwner:     {PermManageEvents, PermCheckIn, PermManageMembers},
+	OrgRoleManager:   {PermManageEvents, PermCheckIn},
+	OrgRoleDoorStaff: {PermCheckIn},
+}
+
+// ErrEventNotFound means no event has the requested ID.
+var ErrEventNotFound = errors.New("event not found")
+
+// ValidOrgRole reports whether role is one of the organization roles.
+func ValidOrgRole(role string) bool {
+	_, ok := orgPermissions[role]
+	return ok
+}
+
+// OrgRoleAllows reports whether members with role have perm.
+func OrgRoleAllows(role string, perm Permission) bool {
+	for _, p := range orgPermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// HoldsOrgPermission reports whether userID has perm in any organization.
+func HoldsOrgPermission(userID int, perm Permission) (bool, error) {
+	rows, err := utils.DB.Query(`SELECT DISTINCT role FROM organization_members WHERE user_id = $1`, userID)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return false, err
+		}
+		if OrgRoleAllows(role, perm) {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// OrgMemberRole returns userID's role in orgID, or "" when they are not a
+// member.
+func OrgMemberRole(orgID, userID int) (string, error) {
+	var role string
+	err := utils.DB.QueryRow(
+		`SELECT role FROM organization_members WHERE organization_id = $1 AND user_id = $2`,
+		orgID, userID,
+	).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// CanInOrg reports whether userID holds perm in orgID.
+func CanInOrg(userID, orgID int, perm Permission) (bool, error) {
+	role, err := OrgMemberRole(orgID, userID)
+	if err != nil {
+		return false, err
+	}
+	return OrgRoleAllows(role, perm), nil
+}
+
+// CanOnEvent reports whether userID holds perm for eventID. Events owned by
+// an organization defer to membership; personal events only allow the user
+// who created them.
+func CanOnEvent(userID, eventID int, perm Permission) (bool, error) {
+	var ownerID, orgID sql.NullInt64
+	err := utils.DB.QueryRow(
+		`SELECT user_id, organization_id FROM events WHERE id = $1`, eventID,
+	).Scan(&ownerID, &orgID)
+	if err == sql.ErrNoRows {
+		return false, ErrEventNotFound
+	} else if err != nil {
+		return false, err
+	}
+	if orgID.Valid {
+		return CanInOrg(userID, int(orgID.Int64), perm)
+	}
+	return ownerID.Valid && int(ownerID.Int64) == userID, nil
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"local-event-backend/utils"
+)
+
+const (
+	otpTTL            = 5 * time.Minute
+	otpMaxAttempts    = 5
+	otpResendCooldown = time.Minute
+	otpHourlyLimit    = 5
+)
+
+var (
+	// ErrOTPRateLimited means a code was requested too recently or too
+	// often for this number.
+	ErrOTPRateLimited = errors.New("too many codes requested")
+	// ErrInvalidOTP means the code is wrong, expired, used up or out of
+	// attempts.
+	ErrInvalidOTP = errors.New("invalid or expired code")
+)
+
+// IssuePhoneOTP creates a 6-digit login code for phone, invalidating any
+// earlier one, and returns it for sending. Only a hash is stored.
+func IssuePhoneOTP(phone string) (string, error) {
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	// Serialize requests for the same number so the limits below hold.
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, "otp:"+phone); err != nil {
+		return "", err
+	}
+
+	var lastSent sql.NullTime
+	var lastHour int
+	err = tx.QueryRow(`
+		SELECT MAX(created_at), COUNT(*) FILTER (WHERE created_at > NOW() - INTERVAL '1 hour')
+		FROM phone_otps WHERE phone = $1`,
+		phone,
+	).Scan(&lastSent, &lastHour)
+	if err != nil {
+		return "", err
+	}
+	if (lastSent.Valid && time.Since(lastSent.Time) < otpResendCooldown) || lastHour >= otpHourlyLimit {
+		return "", ErrOTPRateLimited
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+
+	if _, err := tx.Exec(`UPDATE phone_otps SET consumed_at = NOW() WHERE phone = $1 AND consumed_at IS NULL`, phone); err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO phone_otps (phone, code_hash, expires_at) VALUES ($1, $2, $3)`,
+		phone, hashToken(phone+":"+code), time.Now().Add(otpTTL),
+	)
+	if err != nil {
+		return "", err
+	}
+	return code, tx.Commit()
+}
+
+// VerifyPhoneOTP consumes the current code for phone. Every wrong guess
+// counts against the code; after otpMaxAttempts it stops working.
+func VerifyPhoneOTP(phone, code string) error {
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id, attempts int
+	var codeHash string
+	err = tx.QueryRow(`
+		SELECT id, code_hash, attempts FROM phone_otps
+		WHERE phone = $1 AND consumed_at IS NULL AND expires_at > NOW()
+		ORDER BY created_at DESC LIMIT 1
+		FOR UPDATE`,
+		phone,
+	).Scan(&id, &codeHash, &attempts)
+	if err == sql.ErrNoRows {
+		return ErrInvalidOTP
+	} else if err != nil {
+		return err
+	}
+	if attempts >= otpMaxAttempts {
+		return ErrInvalidOTP
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(phone+":"+code)), []byte(codeHash)) != 1 {
+		if _, err := tx.Exec(`UPDATE phone_otps SET attempts = attempts + 1 WHERE id = $1`, id); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return ErrInvalidOTP
+	}
+
+	if _, err := tx.Exec(`UPDATE phone_otps SET consumed_at = NOW() WHERE id = $1`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
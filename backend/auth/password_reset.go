@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"local-event-backend/utils"
+)
+
+const passwordResetTTL = time.Hour
+
+// ErrInvalidResetToken means the reset token is unknown, used or expired.
+var ErrInvalidResetToken = errors.New("invalid password reset token")
+
+// NewPasswordResetToken stores a single-use reset token for userID and
+// returns it. Only its hash is kept in password_reset_tokens.
+func NewPasswordResetToken(userID int) (string, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = utils.DB.Exec(
+		`INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		userID, hashToken(token), time.Now().Add(passwordResetTTL),
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ResetPassword consumes token, stores passwordHash as the user's new
+// password and invalidates every other outstanding reset token. It returns
+// the user whose password changed; the caller should revoke their sessions.
+func ResetPassword(token, passwordHash string) (int, error) {
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var tokenID, userID int
+	err = tx.QueryRow(`
+		SELECT id, user_id FROM password_reset_tokens
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		FOR UPDATE`,
+		hashToken(token),
+	).Scan(&tokenID, &userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidResetToken
+	} else if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`UPDATE users SET password = $1, has_password = true WHERE id = $2`, passwordHash, userID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL`, userID); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
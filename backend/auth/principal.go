@@ -0,0 +1,41 @@
+// Package auth resolves who is calling the backend. Requests either come from
+// Hasura as an action (trusted via the shared action secret, identity taken
+// from session_variables) or straight from a client with a bearer JWT.
+package auth
+
+import "errors"
+
+// HasuraClaimsKey is the namespace Hasura reads its session claims from.
+const HasuraClaimsKey = "https://hasura.io/jwt/claims"
+
+// ErrUnauthenticated is returned (wrapped) whenever the caller's identity
+// cannot be established.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Principal is the verified identity behind a request.
+type Principal struct {
+	UserID int
+	// Role is the role the request runs as; AllowedRoles every role the
+	// caller may switch to.
+	Role         string
+	AllowedRoles []string
+	// SessionID is the user_sessions family the caller signed in with. On
+	// Hasura action requests it comes from x-hasura-session-id and is empty
+	// for tokens issued before that claim existed.
+	SessionID string
+}
+
+// HasRole reports whether the request runs as role.
+func (p Principal) HasRole(role string) bool {
+	return p.Role == role
+}
+
+// CanAssume reports whether role is among the caller's allowed roles.
+func (p Principal) CanAssume(role string) bool {
+	for _, r := range p.AllowedRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"local-event-backend/utils"
+)
+
+// Purpose tokens are short-lived JWTs for one specific step (verifying an
+// email, finishing a login). They are signed with the same keys as access
+// tokens but carry their own audience, so neither kind is accepted in place
+// of the other.
+const (
+	PurposeVerifyEmail = "verify-email"
+	PurposeOIDCLogin   = "oidc-login"
+	PurposeMFAPending  = "mfa-pending"
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+	oidcLoginTTL         = 10 * time.Minute
+	mfaPendingTTL        = 5 * time.Minute
+)
+
+func purposeAudience(purpose string) string {
+	return utils.JwtAudience() + ":" + purpose
+}
+
+func signPurposeToken(purpose string, userID int, extra jwt.MapClaims, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub": strconv.Itoa(userID),
+		"iss": utils.JwtIssuer(),
+		"aud": purposeAudience(purpose),
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+
+	key := utils.Keys.Active()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+func parsePurposeToken(purpose, tokenStr string) (int, jwt.MapClaims, error) {
+	userID, claims, err := parsePurposeTokenClaims(purpose, tokenStr)
+	if err != nil {
+		return 0, nil, err
+	}
+	if userID <= 0 {
+		return 0, nil, fmt.Errorf("%w: invalid subject", ErrUnauthenticated)
+	}
+	return userID, claims, nil
+}
+
+// parsePurposeTokenClaims verifies a purpose token that may not be tied to a
+// user yet; the returned user ID is then 0.
+func parsePurposeTokenClaims(purpose, tokenStr string) (int, jwt.MapClaims, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(utils.Keys.Algorithms()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(utils.JwtIssuer()),
+		jwt.WithAudience(purposeAudience(purpose)),
+	)
+
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(tokenStr, claims, keyFunc)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	sub, _ := claims["sub"].(string)
+	userID, err := strconv.Atoi(sub)
+	if err != nil || userID < 0 {
+		return 0, nil, fmt.Errorf("%w: invalid subject", ErrUnauthenticated)
+	}
+	return userID, claims, nil
+}
+
+// NewEmailVerificationToken returns a token proving the holder received mail
+// at email. It stops working once the user's email changes.
+func NewEmailVerificationToken(userID int, email string) (string, error) {
+	return signPurposeToken(PurposeVerifyEmail, userID, jwt.MapClaims{"email": email}, emailVerificationTTL)
+}
+
+// ParseEmailVerificationToken returns the user and email a verification
+// token was issued for.
+func ParseEmailVerificationToken(tokenStr string) (int, string, error) {
+	userID, claims, err := parsePurposeToken(PurposeVerifyEmail, tokenStr)
+	if err != nil {
+		return 0, "", err
+	}
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return 0, "", fmt.Errorf("%w: missing email", ErrUnauthenticated)
+	}
+	return userID, email, nil
+}
+
+// OIDCLogin is the state kept in the browser between /auth/oidc/start and the
+// provider redirecting back to /auth/oidc/callback.
+type OIDCLogin struct {
+	Provider string
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewOIDCLogin generates fresh state, nonce and PKCE verifier for provider
+// and returns them along with a signed token to keep in a cookie.
+func NewOIDCLogin(provider string) (OIDCLogin, string, error) {
+	login := OIDCLogin{Provider: provider}
+	for _, dst := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		v, err := newOpaqueToken()
+		if err != nil {
+			return OIDCLogin{}, "", err
+		}
+		*dst = v
+	}
+
+	token, err := signPurposeToken(PurposeOIDCLogin, 0, jwt.MapClaims{
+		"provider": login.Provider,
+		"state":    login.State,
+		"nonce":    login.Nonce,
+		"verifier": login.Verifier,
+	}, oidcLoginTTL)
+	if err != nil {
+		return OIDCLogin{}, "", err
+	}
+	return login, token, nil
+}
+
+// ParseOIDCLogin reads back the state stored by NewOIDCLogin.
+func ParseOIDCLogin(tokenStr string) (OIDCLogin, error) {
+	_, claims, err := parsePurposeTokenClaims(PurposeOIDCLogin, tokenStr)
+	if err != nil {
+		return OIDCLogin{}, err
+	}
+	var login OIDCLogin
+	login.Provider, _ = claims["provider"].(string)
+	login.State, _ = claims["state"].(string)
+	login.Nonce, _ = claims["nonce"].(string)
+	login.Verifier, _ = claims["verifier"].(string)
+	if login.Provider == "" || login.State == "" || login.Nonce == "" || login.Verifier == "" {
+		return OIDCLogin{}, fmt.Errorf("%w: incomplete oidc login state", ErrUnauthenticated)
+	}
+	return login, nil
+}
+
+// NewMFAPendingToken is handed out after a correct password when the user
+// has two-factor authentication on. It can only be exchanged, together with
+// a code, for a real session.
+func NewMFAPendingToken(userID int, rememberMe bool) (string, error) {
+	return signPurposeToken(PurposeMFAPending, userID, jwt.MapClaims{"remember_me": rememberMe}, mfaPendingTTL)
+}
+
+// ParseMFAPendingToken returns the user and remember-me choice of a pending
+// login.
+func ParseMFAPendingToken(tokenStr string) (int, bool, error) {
+	userID, claims, err := parsePurposeToken(PurposeMFAPending, tokenStr)
+	if err != nil {
+		return 0, false, err
+	}
+	rememberMe, _ := claims["remember_me"].(bool)
+	return userID, rememberMe, nil
+}
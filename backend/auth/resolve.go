@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ActionSecretHeader carries the secret Hasura is configured to send with
+// every action request.
+const ActionSecretHeader = "X-Hasura-Action-Secret"
+
+// actionSecret returns the shared secret configured for Hasura actions. When
+// it is empty, session variables are never trusted.
+func actionSecret() string {
+	return os.Getenv("HASURA_ACTION_SECRET")
+}
+
+// Authenticate resolves the Principal for r. A request carrying the correct
+// action secret is trusted to report the caller in session_variables; any
+// other request must present a valid bearer token whose session has not been
+// revoked, optionally choosing one of its allowed roles with X-Hasura-Role.
+// The request body is left readable for the handler.
+func Authenticate(r *http.Request) (Principal, error) {
+	if got := r.Header.Get(ActionSecretHeader); got != "" {
+		want := actionSecret()
+		if want == "" || subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+			return Principal{}, fmt.Errorf("%w: bad action secret", ErrUnauthenticated)
+		}
+		return fromSessionVariables(r)
+	}
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return Principal{}, fmt.Errorf("%w: missing Authorization header", ErrUnauthenticated)
+	}
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return Principal{}, fmt.Errorf("%w: invalid authorization format", ErrUnauthenticated)
+	}
+	principal, err := ParseToken(parts[1])
+	if err != nil {
+		return Principal{}, err
+	}
+	if requested := r.Header.Get(RoleHeader); requested != "" {
+		if !principal.CanAssume(requested) {
+			return Principal{}, fmt.Errorf("%w: role %q not allowed", ErrUnauthenticated, requested)
+		}
+		principal.Role = requested
+	}
+	active, err := sessionActive(principal.SessionID)
+	if err != nil {
+		return Principal{}, err
+	}
+	if !active {
+		return Principal{}, fmt.Errorf("%w: session revoked", ErrUnauthenticated)
+	}
+	return principal, nil
+}
+
+func fromSessionVariables(r *http.Request) (Principal, error) {
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		return Principal{}, err
+	}
+	r.Body = io.NopCloser(bytes.NewBuffer(raw))
+
+	var payload struct {
+		SessionVariables map[string]string `json:"session_variables"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return Principal{}, fmt.Errorf("%w: invalid action payload", ErrUnauthenticated)
+	}
+	// Hasura has already checked x-hasura-role against the allowed roles.
+	principal, err := newPrincipal(payload.SessionVariables["x-hasura-user-id"], payload.SessionVariables["x-hasura-role"])
+	if err != nil {
+		return Principal{}, err
+	}
+	principal.AllowedRoles = []string{principal.Role}
+	principal.SessionID = payload.SessionVariables["x-hasura-session-id"]
+	return principal, nil
+}
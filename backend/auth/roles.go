@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"fmt"
+
+	"local-event-backend/utils"
+)
+
+// Hasura roles a user can hold. They are stored per user in user_roles and
+// issued as x-hasura-allowed-roles.
+const (
+	RoleAttendee  = "attendee"
+	RoleOrganizer = "organizer"
+	RoleAdmin     = "admin"
+)
+
+// RoleHeader lets a client pick which of its allowed roles a request runs as,
+// the same header Hasura honours.
+const RoleHeader = "X-Hasura-Role"
+
+// knownRoles also fixes the order roles are listed in the claims.
+var knownRoles = []string{RoleAttendee, RoleOrganizer, RoleAdmin}
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	for _, r := range knownRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// UserRoles returns the roles granted to userID. Users without any rows are
+// treated as attendees. Organizer also follows from membership of an
+// organization whose role may manage events, and lapses with it; it is
+// never written to user_roles for that.
+func UserRoles(userID int) ([]string, error) {
+	rows, err := utils.DB.Query(`SELECT role FROM user_roles WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	granted := map[string]bool{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		granted[role] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !granted[RoleOrganizer] {
+		member, err := HoldsOrgPermission(userID, PermManageEvents)
+		if err != nil {
+			return nil, err
+		}
+		granted[RoleOrganizer] = member
+	}
+
+	var roles []string
+	for _, r := range knownRoles {
+		if granted[r] {
+			roles = append(roles, r)
+		}
+	}
+	if len(roles) == 0 {
+		roles = []string{RoleAttendee}
+	}
+	return roles, nil
+}
+
+// GrantRole gives userID role. Granting a role twice is a no-op.
+func GrantRole(db execer, userID int, role string) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	_, err := db.Exec(`INSERT INTO user_roles (user_id, role) VALUES ($1, $2) ON CONFLICT DO NOTHING`, userID, role)
+	return err
+}
+
+// IsOrganizer reports whether userID may act as organizer right now: granted
+// the role outright, or through an organization membership.
+func IsOrganizer(userID int) (bool, error) {
+	var granted bool
+	err := utils.DB.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM user_roles WHERE user_id = $1 AND role = $2)`,
+		userID, RoleOrganizer,
+	).Scan(&granted)
+	if err != nil || granted {
+		return granted, err
+	}
+	return HoldsOrgPermission(userID, PermManageEvents)
+}
+
+// RevokeRole takes role away from userID.
+func RevokeRole(userID int, role string) error {
+	_, err := utils.DB.Exec(`DELETE FROM user_roles WHERE user_id = $1 AND role = $2`, userID, role)
+	return err
+}
+
+// defaultRole is the role a token acts as when no X-Hasura-Role is sent:
+// attendee when held, otherwise the first granted role.
+func defaultRole(roles []string) string {
+	for _, r := range roles {
+		if r == RoleAttendee {
+			return r
+		}
+	}
+	return roles[0]
+}
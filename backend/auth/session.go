@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"local-event-backend/utils"
+)
+
+const (
+	refreshTTL           = 24 * time.Hour
+	rememberMeRefreshTTL = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrInvalidRefreshToken means the refresh token is unknown or expired.
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused means an already rotated token was presented
+	// again; the whole session family has been revoked as a precaution.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// Tokens is what a client receives after logging in or refreshing.
+type Tokens struct {
+	UserID       int
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int
+}
+
+// StartSession opens a new session family for userID and returns its first
+// access/refresh token pair. rememberMe selects the longer refresh lifetime.
+func StartSession(userID int, rememberMe bool, userAgent, ip string) (Tokens, error) {
+	familyID := uuid.New().String()
+	refreshToken, err := insertSession(utils.DB, userID, familyID, rememberMe, userAgent, ip)
+	if err != nil {
+		return Tokens{}, err
+	}
+	return issueTokens(userID, familyID, refreshToken)
+}
+
+// Refresh rotates refreshToken: the presented token is revoked and a new one
+// in the same family is returned. Presenting a token that was already
+// rotated revokes the family and returns ErrRefreshTokenReused.
+func Refresh(refreshToken, userAgent, ip string) (Tokens, error) {
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		return Tokens{}, err
+	}
+	defer tx.Rollback()
+
+	var (
+		sessionID  int
+		userID     int
+		familyID   string
+		rememberMe bool
+		expiresAt  time.Time
+		revokedAt  sql.NullTime
+	)
+	err = tx.QueryRow(`
+		SELECT id, user_id, family_id, remember_me, expires_at, revoked_at
+		FROM user_sessions WHERE refresh_token_hash = $1
+		FOR UPDATE`,
+		hashToken(refreshToken),
+	).Scan(&sessionID, &userID, &familyID, &rememberMe, &expiresAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return Tokens{}, ErrInvalidRefreshToken
+	} else if err != nil {
+		return Tokens{}, err
+	}
+
+	if revokedAt.Valid {
+		// Commit the family revocation even though the caller gets an error.
+		if _, err := tx.Exec(`UPDATE user_sessions SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`, familyID); err != nil {
+			return Tokens{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return Tokens{}, err
+		}
+		return Tokens{}, ErrRefreshTokenReused
+	}
+	if time.Now().After(expiresAt) {
+		return Tokens{}, ErrInvalidRefreshToken
+	}
+
+	newToken, err := insertSession(tx, userID, familyID, rememberMe, userAgent, ip)
+	if err != nil {
+		return Tokens{}, err
+	}
+	_, err = tx.Exec(`
+		UPDATE user_sessions
+		SET revoked_at = NOW(),
+		    replaced_by = (SELECT id FROM user_sessions WHERE refresh_token_hash = $2)
+		WHERE id = $1`,
+		sessionID, hashToken(newToken),
+	)
+	if err != nil {
+		return Tokens{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Tokens{}, err
+	}
+	return issueTokens(userID, familyID, newToken)
+}
+
+// Logout revokes the session family refreshToken belongs to. Unknown tokens
+// are ignored so logging out twice is harmless.
+func Logout(refreshToken string) error {
+	_, err := utils.DB.Exec(`
+		UPDATE user_sessions SET revoked_at = NOW()
+		WHERE revoked_at IS NULL AND family_id = (
+			SELECT family_id FROM user_sessions WHERE refresh_token_hash = $1
+		)`,
+		hashToken(refreshToken),
+	)
+	return err
+}
+
+// LogoutAll revokes every session of userID, e.g. after a password reset.
+func LogoutAll(userID int) error {
+	_, err := utils.DB.Exec(`UPDATE user_sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	return err
+}
+
+// LogoutOthers revokes every session of userID except the family keep, so
+// the device that changed the password stays signed in.
+func LogoutOthers(userID int, keep string) error {
+	if keep == "" {
+		return LogoutAll(userID)
+	}
+	_, err := utils.DB.Exec(
+		`UPDATE user_sessions SET revoked_at = NOW() WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL`,
+		userID, keep,
+	)
+	return err
+}
+
+// sessionActive reports whether the session family behind an access token
+// still has a live refresh token.
+func sessionActive(familyID string) (bool, error) {
+	var active bool
+	err := utils.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM user_sessions
+			WHERE family_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		)`,
+		familyID,
+	).Scan(&active)
+	return active, err
+}
+
+// SessionStartedAt returns when userID signed in to the session family
+// familyID. Refreshing keeps the family, so this is the last real login.
+func SessionStartedAt(userID int, familyID string) (time.Time, error) {
+	var started sql.NullTime
+	err := utils.DB.QueryRow(
+		`SELECT MIN(created_at) FROM user_sessions WHERE family_id = $1 AND user_id = $2`,
+		familyID, userID,
+	).Scan(&started)
+	if err == nil && !started.Valid {
+		err = sql.ErrNoRows
+	}
+	return started.Time, err
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertSession(db execer, userID int, familyID string, rememberMe bool, userAgent, ip string) (string, error) {
+	refreshToken, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	ttl := refreshTTL
+	if rememberMe {
+		ttl = rememberMeRefreshTTL
+	}
+	_, err = db.Exec(`
+		INSERT INTO user_sessions (user_id, family_id, refresh_token_hash, remember_me, user_agent, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		userID, familyID, hashToken(refreshToken), rememberMe, userAgent, ip, time.Now().Add(ttl),
+	)
+	if err != nil {
+		return "", fmt.Errorf("insert session: %w", err)
+	}
+	return refreshToken, nil
+}
+
+func issueTokens(userID int, familyID, refreshToken string) (Tokens, error) {
+	roles, err := UserRoles(userID)
+	if err != nil {
+		return Tokens{}, err
+	}
+	accessToken, err := utils.GenerateToken(userID, familyID, roles, defaultRole(roles))
+	if err != nil {
+		return Tokens{}, err
+	}
+	return Tokens{
+		UserID:       userID,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(utils.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// newOpaqueToken returns a random URL-safe token. Only its hashToken digest
+// is ever stored.
+func newOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Attempts is the failure counter kept per throttle key.
+type Attempts struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// AttemptStore keeps failure counters. MemoryAttemptStore suits a single
+// instance; PostgresAttemptStore shares counters between instances.
+type AttemptStore interface {
+	Get(key string) (Attempts, error)
+	// Reserve counts an attempt before it is made, starting over when the
+	// previous one is older than window. It returns the counter as it stood
+	// just before, so concurrent callers each see the attempts ahead of them.
+	Reserve(key string, window time.Duration) (Attempts, error)
+	// Release gives back one reserved attempt that turned out to succeed.
+	Release(key string) error
+	Reset(key string) error
+}
+
+// ThrottlePolicy turns a failure count into a wait: the first FreeAttempts
+// failures cost nothing, then the delay doubles from BaseDelay up to
+// MaxDelay, and from LockoutAfter failures the key is locked for LockoutFor.
+// Counters are forgotten after Window without failures.
+type ThrottlePolicy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockoutAfter int
+	LockoutFor   time.Duration
+	Window       time.Duration
+}
+
+// Wait returns how long a key with attempts must wait at now.
+func (p ThrottlePolicy) Wait(attempts Attempts, now time.Time) time.Duration {
+	if attempts.Failures == 0 || now.Sub(attempts.LastFailure) > p.Window {
+		return 0
+	}
+
+	var delay time.Duration
+	switch {
+	case attempts.Failures >= p.LockoutAfter:
+		delay = p.LockoutFor
+	case attempts.Failures > p.FreeAttempts:
+		delay = p.BaseDelay << uint(attempts.Failures-p.FreeAttempts-1)
+		if delay > p.MaxDelay || delay <= 0 {
+			delay = p.MaxDelay
+		}
+	default:
+		return 0
+	}
+
+	if wait := attempts.LastFailure.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// LoginGuard throttles login attempts per account and per client IP.
+type LoginGuard struct {
+	Store   AttemptStore
+	Account ThrottlePolicy
+	IP      ThrottlePolicy
+}
+
+// NewLoginGuard returns a guard over store with the default policies.
+func NewLoginGuard(store AttemptStore) *LoginGuard {
+	return &LoginGuard{
+		Store: store,
+		Account: ThrottlePolicy{
+			FreeAttempts: 3,
+			BaseDelay:    time.Second,
+			MaxDelay:     5 * time.Minute,
+			LockoutAfter: 10,
+			LockoutFor:   15 * time.Minute,
+			Window:       15 * time.Minute,
+		},
+		// Looser, since many attendees can share one NAT address.
+		IP: ThrottlePolicy{
+			FreeAttempts: 20,
+			BaseDelay:    time.Second,
+			MaxDelay:     time.Minute,
+			LockoutAfter: 100,
+			LockoutFor:   15 * time.Minute,
+			Window:       15 * time.Minute,
+		},
+	}
+}
+
+// Logins is the guard LoginHandler consults. main swaps in a Postgres-backed
+// store when several instances share the load.
+var Logins = NewLoginGuard(NewMemoryAttemptStore())
+
+func accountKey(email string) string { return "email:" + strings.ToLower(strings.TrimSpace(email)) }
+func ipKey(ip string) string         { return "ip:" + ip }
+
+// Attempt reserves a try at email from ip and returns how long the caller
+// must wait before making it. The attempt counts against both keys before
+// the password is checked, so a burst of parallel requests cannot all pass
+// before any failure is recorded. Attempts refused with a wait count too.
+func (g *LoginGuard) Attempt(email, ip string) (time.Duration, error) {
+	now := time.Now()
+	account, err := g.Store.Reserve(accountKey(email), g.Account.Window)
+	if err != nil {
+		return 0, err
+	}
+	byIP, err := g.Store.Reserve(ipKey(ip), g.IP.Window)
+	if err != nil {
+		return 0, err
+	}
+
+	wait := g.Account.Wait(account, now)
+	if w := g.IP.Wait(byIP, now); w > wait {
+		wait = w
+	}
+	return wait, nil
+}
+
+// Succeeded clears the account counter and gives back the IP's reserved
+// attempt. The IP's failures are left alone so one good password does not
+// unlock guessing at other accounts.
+func (g *LoginGuard) Succeeded(email, ip string) error {
+	if err := g.Store.Reset(accountKey(email)); err != nil {
+		return err
+	}
+	return g.Store.Release(ipKey(ip))
+}
+
+// MemoryAttemptStore keeps counters in process memory.
+type MemoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempts
+}
+
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{attempts: map[string]Attempts{}}
+}
+
+func (s *MemoryAttemptStore) Get(key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts[key], nil
+}
+
+func (s *MemoryAttemptStore) Reserve(key string, window time.Duration) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	prior := s.attempts[key]
+	if now.Sub(prior.LastFailure) > window {
+		prior.Failures = 0
+	}
+	s.attempts[key] = Attempts{Failures: prior.Failures + 1, LastFailure: now}
+	return prior, nil
+}
+
+func (s *MemoryAttemptStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a, ok := s.attempts[key]; ok && a.Failures > 0 {
+		a.Failures--
+		s.attempts[key] = a
+	}
+	return nil
+}
+
+func (s *MemoryAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
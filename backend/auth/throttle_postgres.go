@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PostgresAttemptStore keeps counters in the login_throttle table so every
+// backend instance sees the same failures.
+type PostgresAttemptStore struct {
+	DB *sql.DB
+}
+
+func (s PostgresAttemptStore) Get(key string) (Attempts, error) {
+	var a Attempts
+	err := s.DB.QueryRow(`SELECT failures, last_failure_at FROM login_throttle WHERE key = $1`, key).
+		Scan(&a.Failures, &a.LastFailure)
+	if err == sql.ErrNoRows {
+		return Attempts{}, nil
+	}
+	return a, err
+}
+
+// Reserve increments the counter in one statement, which locks the row, so
+// parallel attempts on a key are counted one after another.
+func (s PostgresAttemptStore) Reserve(key string, window time.Duration) (Attempts, error) {
+	var failures int
+	var previous sql.NullTime
+	err := s.DB.QueryRow(`
+		INSERT INTO login_throttle (key, failures, last_failure_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_throttle.last_failure_at < NOW() - make_interval(secs => $2) THEN 1
+				ELSE login_throttle.failures + 1
+			END,
+			previous_failure_at = login_throttle.last_failure_at,
+			last_failure_at = NOW()
+		RETURNING failures, previous_failure_at`,
+		key, window.Seconds(),
+	).Scan(&failures, &previous)
+	if err != nil {
+		return Attempts{}, err
+	}
+	return Attempts{Failures: failures - 1, LastFailure: previous.Time}, nil
+}
+
+func (s PostgresAttemptStore) Release(key string) error {
+	_, err := s.DB.Exec(`UPDATE login_throttle SET failures = failures - 1 WHERE key = $1 AND failures > 0`, key)
+	return err
+}
+
+func (s PostgresAttemptStore) Reset(key string) error {
+	_, err := s.DB.Exec(`DELETE FROM login_throttle WHERE key = $1`, key)
+	return err
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLoginGuardLimitsParallelAttempts(t *testing.T) {
+	g := NewLoginGuard(NewMemoryAttemptStore())
+	const attempts = 50
+
+	var (
+		mu      sync.Mutex
+		allowed int
+		start   = make(chan struct{})
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			wait, err := g.Attempt("user@example.com", "192.0.2.1")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	// An attempt only waits once more than FreeAttempts precede it.
+	if want := g.Account.FreeAttempts + 1; allowed != want {
+		t.Fatalf("%d of %d parallel attempts allowed, want %d", allowed, attempts, want)
+	}
+}
+
+func TestLoginGuardSucceededClearsAccount(t *testing.T) {
+	g := NewLoginGuard(NewMemoryAttemptStore())
+	for i := 0; i < g.Account.LockoutAfter; i++ {
+		g.Attempt("user@example.com", "192.0.2.1")
+	}
+	if err := g.Succeeded("user@example.com", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	wait, err := g.Attempt("user@example.com", "192.0.2.1")
+	if err != nil || wait != 0 {
+		t.Fatalf("Attempt after success = %v, %v; want no wait", wait, err)
+	}
+}
@@ -0,0 +1,183 @@
+package events
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"local-event-backend/recurrence"
+)
+
+// Horizon is how far ahead occurrences of a recurring event are created.
+// The scheduler keeps extending open-ended series as time passes.
+const Horizon = 366 * 24 * time.Hour
+
+// MaxOccurrences bounds how many instances one expansion of a series
+// returns. Only instances inside the requested window count toward it.
+const MaxOccurrences = 1000
+
+// Series is a recurring event: its first instance's schedule, its rule and
+// the instances left out.
+type Series struct {
+	Schedule
+	Rule     *recurrence.Rule
+	ExDates  []time.Time
+	Capacity *int
+}
+
+// execQueryer is satisfied by *sql.DB and *sql.Tx.
+type execQueryer interface {
+	rowQueryer
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// LoadSeries reads eventID's recurrence. The bool is false for events
+// without a rule.
+func LoadSeries(db rowQueryer, eventID int) (Series, bool, error) {
+	var s Series
+	schedule, err := LoadSchedule(db, eventID)
+	if err != nil {
+		return s, false, err
+	}
+	s.Schedule = schedule
+
+	var rule sql.NullString
+	var exdates []byte
+	var capacity sql.NullInt64
+	err = db.QueryRow(
+		`SELECT rrule, array_to_json(exdates), occurrence_capacity FROM events WHERE id = $1`, eventID,
+	).Scan(&rule, &exdates, &capacity)
+	if err != nil {
+		return s, false, err
+	}
+	if capacity.Valid {
+		n := int(capacity.Int64)
+		s.Capacity = &n
+	}
+	if !rule.Valid || rule.String == "" {
+		return s, false, nil
+	}
+	if s.Rule, err = recurrence.ParseInLocation(rule.String, s.Location); err != nil {
+		return s, false, err
+	}
+	if s.ExDates, err = decodeTimes(exdates); err != nil {
+		return s, false, err
+	}
+	return s, true, nil
+}
+
+func decodeTimes(raw []byte) ([]time.Time, error) {
+	var strs []string
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &strs); err != nil {
+			return nil, err
+		}
+	}
+	out := make([]time.Time, 0, len(strs))
+	for _, s := range strs {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// TimeArray encodes ts for a timestamptz[] parameter.
+func TimeArray(ts []time.Time) interface{} {
+	strs := make([]string, len(ts))
+	for i, t := range ts {
+		strs[i] = t.Format(time.RFC3339Nano)
+	}
+	return pq.Array(strs)
+}
+
+// Starts returns the series' instance start times from from up to end,
+// without the excluded ones.
+func (s Series) Starts(from, end time.Time) []time.Time {
+	all := s.Rule.Between(s.StartsAt.In(s.Location), from, end, MaxOccurrences)
+	return recurrence.Exclude(all, s.ExDates)
+}
+
+// SyncOccurrences makes eventID's upcoming occurrences match its rule. New
+// instances are inserted; unmodified ones are moved to the series' current
+// times and capacity; unmodified, unsold ones the rule no longer produces
+// are removed. Past occurrences and ones edited individually are left alone.
+// An event without a rule loses its unsold upcoming occurrences.
+func SyncOccurrences(db execQueryer, eventID int) error {
+	series, recurring, err := LoadSeries(db, eventID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var upcoming []time.Time
+	if recurring {
+		for _, t := range series.Starts(now, now.Add(Horizon)) {
+			if t.After(now) {
+				upcoming = append(upcoming, t)
+			}
+		}
+	}
+
+	duration := series.EndsAt.Sub(series.StartsAt)
+	for _, start := range upcoming {
+		var doors *time.Time
+		if series.DoorsOpenAt != nil {
+			d := start.Add(series.DoorsOpenAt.Sub(series.StartsAt))
+			doors = &d
+		}
+		_, err := db.Exec(`
+			INSERT INTO event_occurrences (event_id, original_starts_at, starts_at, ends_at, doors_open_at, capacity)
+			VALUES ($1, $2, $2, $3, $4, $5)
+			ON CONFLICT (event_id, original_starts_at) DO UPDATE SET
+				starts_at = EXCLUDED.starts_at,
+				ends_at = EXCLUDED.ends_at,
+				doors_open_at = EXCLUDED.doors_open_at,
+				capacity = CASE WHEN EXCLUDED.capacity IS NULL THEN NULL
+				                ELSE GREATEST(EXCLUDED.capacity, event_occurrences.tickets_sold) END
+			WHERE NOT event_occurrences.modified`,
+			eventID, start, start.Add(duration), doors, series.Capacity)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec(`
+		DELETE FROM event_occurrences
+		WHERE event_id = $1 AND NOT modified AND tickets_sold = 0 AND starts_at > NOW()
+		  AND NOT (original_starts_at = ANY ($2::timestamptz[]))`,
+		eventID, TimeArray(upcoming))
+	return err
+}
+
+// extendSeries keeps every live recurring event's occurrences a full
+// Horizon ahead.
+func extendSeries(db *sql.DB) error {
+	rows, err := db.Query(
+		`SELECT id FROM events WHERE rrule IS NOT NULL AND status IN ($1, $2, $3)`,
+		string(Draft), string(Published), string(Postponed))
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := SyncOccurrences(db, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
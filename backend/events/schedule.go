@@ -0,0 +1,118 @@
+package events
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	// Embed the zone database so IANA names resolve even on hosts and
+	// containers without /usr/share/zoneinfo.
+	_ "time/tzdata"
+)
+
+// DefaultTimeZone is used for events created without one and for rows that
+// predate per-event zones.
+const DefaultTimeZone = "Africa/Addis_Ababa"
+
+// MaxDuration bounds how long one event may run; longer spans are almost
+// always a typo in the end date.
+const MaxDuration = 31 * 24 * time.Hour
+
+// Schedule is when an event happens. Times are absolute; Location is the
+// event's own zone, used to read naive input and to render times.
+type Schedule struct {
+	StartsAt    time.Time
+	EndsAt      time.Time
+	DoorsOpenAt *time.Time
+	Location    *time.Location
+}
+
+var errLocalZone = errors.New("time zone must be an IANA name such as Africa/Addis_Ababa")
+
+// LoadLocation resolves an IANA zone name. An empty name means
+// DefaultTimeZone; "Local" is rejected because it depends on the server.
+func LoadLocation(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = DefaultTimeZone
+	}
+	if name == "Local" {
+		return nil, errLocalZone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, errLocalZone
+	}
+	return loc, nil
+}
+
+var localLayouts = []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+// ParseTime reads s as an RFC 3339 timestamp, or as a wall-clock time such
+// as 2026-01-31T18:30 in loc. The bool reports whether s was a bare date
+// (YYYY-MM-DD), which is read as midnight in loc.
+func ParseTime(s string, loc *time.Location) (time.Time, bool, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, false, nil
+	}
+	for _, layout := range localLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, false, nil
+		}
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, loc)
+	return t, err == nil, err
+}
+
+// LocalDate is the calendar date the event starts on in its own zone, kept
+// in events.event_date for older clients.
+func (s Schedule) LocalDate() string {
+	return s.StartsAt.In(s.Location).Format("2006-01-02")
+}
+
+// MultiDay reports whether the event ends on a later local date than it
+// starts. An all-day event ending at the next midnight is a single day.
+func (s Schedule) MultiDay() bool {
+	end := s.EndsAt.In(s.Location).Add(-time.Nanosecond)
+	return end.Format("2006-01-02") != s.LocalDate()
+}
+
+// AllDay reports whether the event runs from one local midnight to another,
+// as events created from a bare date do.
+func (s Schedule) AllDay() bool {
+	start, end := s.StartsAt.In(s.Location), s.EndsAt.In(s.Location)
+	midnight := func(t time.Time) bool {
+		h, m, sec := t.Clock()
+		return h == 0 && m == 0 && sec == 0 && t.Nanosecond() == 0
+	}
+	return midnight(start) && midnight(end) && end.After(start)
+}
+
+// rowQueryer is satisfied by *sql.DB and *sql.Tx.
+type rowQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// LoadSchedule reads eventID's stored schedule.
+func LoadSchedule(db rowQueryer, eventID int) (Schedule, error) {
+	var s Schedule
+	var doors sql.NullTime
+	var zone string
+	err := db.QueryRow(
+		`SELECT starts_at, ends_at, doors_open_at, time_zone FROM events WHERE id = $1`, eventID,
+	).Scan(&s.StartsAt, &s.EndsAt, &doors, &zone)
+	if err == sql.ErrNoRows {
+		return s, ErrNotFound
+	} else if err != nil {
+		return s, err
+	}
+	if doors.Valid {
+		s.DoorsOpenAt = &doors.Time
+	}
+	if s.Location, err = LoadLocation(zone); err != nil {
+		return s, err
+	}
+	return s, nil
+}
@@ -0,0 +1,64 @@
+package events
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"local-event-backend/utils"
+)
+
+// extendEvery is how often recurring series are extended to the horizon.
+const extendEvery = time.Hour
+
+// RunScheduler performs the time-based transitions every interval until ctx
+// is done: drafts whose publish_at has passed are published (once their
+// creator has a verified email, as for /publish-event), and published
+// events that have ended are completed. Recurring series are also topped up
+// with new occurrences.
+func RunScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var extended time.Time
+	for {
+		runScheduledTransitions()
+		if time.Since(extended) >= extendEvery {
+			if err := extendSeries(utils.DB); err != nil {
+				log.Println("❌ Extending recurring events failed:", err)
+			}
+			extended = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func runScheduledTransitions() {
+	res, err := utils.DB.Exec(`
+		UPDATE events SET status = $1, published_at = NOW(), publish_at = NULL
+		WHERE status = $2 AND publish_at IS NOT NULL AND publish_at <= NOW()
+		  AND EXISTS (SELECT 1 FROM users u WHERE u.id = events.user_id AND u.email_verified_at IS NOT NULL)`,
+		string(Published), string(Draft))
+	if err != nil {
+		log.Println("❌ Scheduled publish failed:", err)
+	} else if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("📣 Published %d scheduled events\n", n)
+	}
+
+	res, err = utils.DB.Exec(`
+		UPDATE events SET status = $1
+		WHERE status = $2 AND ends_at < NOW()
+		  AND NOT EXISTS (
+		    SELECT 1 FROM event_occurrences o
+		    WHERE o.event_id = events.id AND o.status = 'scheduled' AND o.ends_at >= NOW()
+		  )`,
+		string(Completed), string(Published))
+	if err != nil {
+		log.Println("❌ Completing past events failed:", err)
+	} else if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("🏁 Completed %d past events\n", n)
+	}
+}
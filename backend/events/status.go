@@ -0,0 +1,103 @@
+// Package events holds the event lifecycle: the statuses an event moves
+// through, which moves are allowed, and the scheduler that performs the
+// time-based ones.
+package events
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Status is the lifecycle state stored in events.status.
+type Status string
+
+const (
+	// Draft events are only visible to their organizers. A draft with a
+	// publish_at time is published by the scheduler when it arrives.
+	Draft Status = "draft"
+	// Published events are listed and can sell tickets.
+	Published Status = "published"
+	// Postponed events keep their tickets but sell no more until they are
+	// published again with a new date.
+	Postponed Status = "postponed"
+	// Cancelled events are final; tickets and sales are kept for refunds.
+	Cancelled Status = "cancelled"
+	// Completed events have taken place.
+	Completed Status = "completed"
+)
+
+var transitions = map[Status][]Status{
+	Draft:     {Published, Cancelled},
+	Published: {Postponed, Cancelled, Completed},
+	Postponed: {Published, Cancelled},
+}
+
+// ErrNotFound means no event has the requested ID.
+var ErrNotFound = errors.New("event not found")
+
+// TransitionError reports a move the state machine does not allow.
+type TransitionError struct {
+	From, To Status
+}
+
+func (e *TransitionError) Error() string {
+	if e.From == e.To {
+		return fmt.Sprintf("event is already %s", e.From)
+	}
+	return fmt.Sprintf("a %s event cannot become %s", e.From, e.To)
+}
+
+// ParseStatus checks s is a known status.
+func ParseStatus(s string) (Status, error) {
+	switch st := Status(s); st {
+	case Draft, Published, Postponed, Cancelled, Completed:
+		return st, nil
+	}
+	return "", fmt.Errorf("unknown event status %q", s)
+}
+
+// CanTransition reports whether an event in from may move to to.
+func CanTransition(from, to Status) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// SellsTickets reports whether tickets can be bought for an event in s.
+func (s Status) SellsTickets() bool {
+	return s == Published
+}
+
+// Transition locks eventID inside tx, checks the move is allowed and stores
+// the new status with its timestamp column. It returns the previous status.
+func Transition(tx *sql.Tx, eventID int, to Status) (Status, error) {
+	var current string
+	err := tx.QueryRow(`SELECT status FROM events WHERE id = $1 FOR UPDATE`, eventID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", err
+	}
+	from := Status(current)
+	if !CanTransition(from, to) {
+		return from, &TransitionError{From: from, To: to}
+	}
+
+	query := `UPDATE events SET status = $2 WHERE id = $1`
+	switch to {
+	case Published:
+		query = `UPDATE events SET status = $2, published_at = NOW(), publish_at = NULL WHERE id = $1`
+	case Postponed:
+		query = `UPDATE events SET status = $2, postponed_at = NOW() WHERE id = $1`
+	case Cancelled:
+		query = `UPDATE events SET status = $2, cancelled_at = NOW() WHERE id = $1`
+	}
+	if _, err := tx.Exec(query, eventID, string(to)); err != nil {
+		return from, err
+	}
+	return from, nil
+}
@@ -5,7 +5,6 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"local-event-backend/utils"
 )
 
@@ -25,13 +24,13 @@ type BookmarkResponse struct {
 }
 
 func CreateBookmarkHandler(c *gin.Context) {
-	userID, ok := getUserIDFromAuthHeader(c)
+	userID, ok := currentUserID(c)
 	if !ok {
 		return
 	}
 
 	var req CreateBookmarkRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := bindInput(c, &req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"message": "Invalid request body",
 		})
@@ -62,13 +61,13 @@ func CreateBookmarkHandler(c *gin.Context) {
 }
 
 func DeleteBookmarkHandler(c *gin.Context) {
-	userID, ok := getUserIDFromAuthHeader(c)
+	userID, ok := currentUserID(c)
 	if !ok {
 		return
 	}
 
 	var req DeleteBookmarkRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := bindInput(c, &req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"message": "Invalid request body",
 		})
@@ -90,44 +89,3 @@ func DeleteBookmarkHandler(c *gin.Context) {
 		"message": "Bookmark removed",
 	})
 }
-
-func getUserIDFromAuthHeader(c *gin.Context) (int, bool) {
-	authHeader := c.GetHeader("Authorization")
-	if authHeader == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "Missing Authorization header"})
-		return 0, false
-	}
-	tokenString := authHeader[len("Bearer "):]
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return utils.JwtSecret, nil
-	})
-	if err != nil || !token.Valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid token"})
-		return 0, false
-	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid token claims"})
-		return 0, false
-	}
-	hasura, ok := claims["https://hasura.io/jwt/claims"].(map[string]interface{})
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "Missing Hasura claims"})
-		return 0, false
-	}
-	userIDStr, ok := hasura["x-hasura-user-id"].(string)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "Missing user id claim"})
-		return 0, false
-	}
-	return mustAtoi(userIDStr), true
-}
-
-
-func mustAtoi(s string) int {
-	var i int
-	for _, c := range s {
-		i = i*10 + int(c-'0')
-	}
-	return i
-}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"local-event-backend/auth"
+	"local-event-backend/events"
+	"local-event-backend/ical"
+	"local-event-backend/utils"
+)
+
+// feedRefresh is how often calendar apps are asked to re-fetch a feed.
+const feedRefresh = time.Hour
+
+// maxFeedEvents bounds the events in one feed.
+const maxFeedEvents = 500
+
+// feedWindow keeps events that ended within the last 30 days, and series
+// with occurrences that did, so recent history stays in subscribers'
+// calendars. Drafts never appear.
+const feedWindow = `e.status <> 'draft' AND (e.ends_at > NOW() - interval '30 days' OR EXISTS (
+	SELECT 1 FROM event_occurrences o WHERE o.event_id = e.id AND o.ends_at > NOW() - interval '30 days'))`
+
+// feedUpcoming is true for events, or series, with a date still to come.
+// When a feed has more than maxFeedEvents, these win over past ones.
+const feedUpcoming = `(e.ends_at >= NOW() OR EXISTS (
+	SELECT 1 FROM event_occurrences o WHERE o.event_id = e.id AND o.ends_at >= NOW()))`
+
+// publicURL is the backend's own base URL, used in feed links.
+func publicURL() string {
+	if u := os.Getenv("PUBLIC_URL"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return "http://localhost:8082"
+}
+
+// calendarDomain is the right-hand side of event UIDs, so they stay unique
+// across calendars from other sites.
+func calendarDomain() string {
+	if u, err := url.Parse(appURL()); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "minab.local"
+}
+
+// icsParam reads a path parameter that must end in ".ics" and returns the
+// part before it.
+func icsParam(c *gin.Context, name string) (string, bool) {
+	v := c.Param(name)
+	if !strings.HasSuffix(v, ".ics") || len(v) == len(".ics") {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Not found"})
+		return "", false
+	}
+	return strings.TrimSuffix(v, ".ics"), true
+}
+
+func icsStatus(status string) string {
+	switch events.Status(status) {
+	case events.Cancelled:
+		return ical.StatusCancelled
+	case events.Postponed:
+		return ical.StatusTentative
+	default:
+		return ical.StatusConfirmed
+	}
+}
+
+// loadCalendarEvents reads the events matching where (over events e) as
+// VEVENTs, upcoming ones first and the soonest of those first. Recurring events carry their rule, and each individually edited
+// or cancelled occurrence becomes an override with a RECURRENCE-ID.
+func loadCalendarEvents(where string, args ...interface{}) ([]ical.Event, error) {
+	rows, err := utils.DB.Query(`
+		SELECT e.id, e.title, e.description, e.venue_name, e.address, e.location_lat, e.location_lng,
+		       e.status, e.created_at, e.updated_at
+		FROM events e
+		WHERE `+where+`
+		ORDER BY `+feedUpcoming+` DESC,
+		         CASE WHEN `+feedUpcoming+` THEN e.starts_at END ASC,
+		         e.starts_at DESC
+		LIMIT `+strconv.Itoa(maxFeedEvents), args...)
+	if err != nil {
+		return nil, err
+	}
+	type row struct {
+		id                     int
+		event                  ical.Event
+		created, updated       sql.NullTime
+		lat, lng               sql.NullFloat64
+		description, venue, at sql.NullString
+	}
+	var found []row
+	for rows.Next() {
+		var r row
+		var status string
+		if err := rows.Scan(&r.id, &r.event.Summary, &r.description, &r.venue, &r.at, &r.lat, &r.lng,
+			&status, &r.created, &r.updated); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		r.event.Status = icsStatus(status)
+		found = append(found, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var out []ical.Event
+	for _, r := range found {
+		e := r.event
+		e.UID = fmt.Sprintf("event-%d@%s", r.id, calendarDomain())
+		e.URL = fmt.Sprintf("%s/events/%d", appURL(), r.id)
+		e.Description = r.description.String
+		var place []string
+		for _, s := range []string{r.venue.String, r.at.String} {
+			if s = strings.TrimSpace(s); s != "" {
+				place = append(place, s)
+			}
+		}
+		e.Location = strings.Join(place, ", ")
+		if r.lat.Valid && r.lng.Valid {
+			e.Geo = &ical.Geo{Lat: r.lat.Float64, Lng: r.lng.Float64}
+		}
+		e.Created = r.created.Time
+		e.LastModified = r.updated.Time
+
+		series, recurring, err := events.LoadSeries(utils.DB, r.id)
+		if err != nil {
+			return nil, err
+		}
+		e.Start, e.End, e.TimeZone = series.StartsAt, series.EndsAt, series.Location
+		e.AllDay = series.AllDay()
+		if !recurring {
+			out = append(out, e)
+			continue
+		}
+		e.Rule, e.ExDates = series.Rule, series.ExDates
+		out = append(out, e)
+
+		overrides, err := occurrenceOverrides(e, r.id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, overrides...)
+	}
+	return out, nil
+}
+
+// occurrenceOverrides turns master's edited occurrences into overrides.
+func occurrenceOverrides(master ical.Event, eventID int) ([]ical.Event, error) {
+	rows, err := utils.DB.Query(`
+		SELECT original_starts_at, starts_at, ends_at, status
+		FROM event_occurrences WHERE event_id = $1 AND modified
+		ORDER BY original_starts_at`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ical.Event
+	for rows.Next() {
+		o := master
+		o.Rule, o.ExDates = nil, nil
+		var status string
+		if err := rows.Scan(&o.RecurrenceID, &o.Start, &o.End, &status); err != nil {
+			return nil, err
+		}
+		if status == "cancelled" {
+			o.Status = ical.StatusCancelled
+		}
+		// An edited occurrence of an all-day series may now have times.
+		o.AllDay = master.AllDay && (events.Schedule{StartsAt: o.Start, EndsAt: o.End, Location: o.TimeZone}).AllDay()
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+func writeCalendar(c *gin.Context, cal ical.Calendar, cache string) {
+	c.Header("Cache-Control", cache)
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", cal.Bytes())
+}
+
+// EventCalendarHandler serves GET /events/:id.ics.
+func EventCalendarHandler(c *gin.Context) {
+	raw, ok := icsParam(c, "file")
+	if !ok {
+		return
+	}
+	eventID, err := strconv.Atoi(raw)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Event not found"})
+		return
+	}
+
+	list, err := loadCalendarEvents(`e.id = $1 AND e.status <> 'draft'`, eventID)
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	if len(list) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Event not found"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="event-%d.ics"`, eventID))
+	writeCalendar(c, ical.Calendar{Events: list}, "public, max-age=300")
+}
+
+type CalendarFeedResponse struct {
+	FeedURL   string `json:"feed_url"`
+	WebcalURL string `json:"webcal_url"`
+	Message   string `json:"message"`
+}
+
+// CalendarFeedHandler issues the caller a personal feed URL. Calling it
+// again replaces the URL and stops the old one working.
+func CalendarFeedHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	token, err := auth.NewCalendarFeedToken(userID)
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	feed := fmt.Sprintf("%s/calendars/users/%s.ics", publicURL(), token)
+	webcal := "webcal://" + strings.TrimPrefix(strings.TrimPrefix(feed, "https://"), "http://")
+	c.JSON(http.StatusOK, CalendarFeedResponse{
+		FeedURL:   feed,
+		WebcalURL: webcal,
+		Message:   "Any earlier feed URL no longer works",
+	})
+}
+
+// UserCalendarHandler serves a user's feed of bookmarked, followed and
+// ticketed events. The token in the URL is the only credential, since
+// calendar apps cannot send ours.
+func UserCalendarHandler(c *gin.Context) {
+	token, ok := icsParam(c, "file")
+	if !ok {
+		return
+	}
+	userID, err := auth.CalendarFeedUser(token)
+	if err == auth.ErrInvalidFeedToken {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Calendar not found"})
+		return
+	} else if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	list, err := loadCalendarEvents(feedWindow+` AND e.id IN (
+		SELECT event_id FROM event_bookmarks WHERE user_id = $1
+		UNION SELECT event_id FROM event_followers WHERE user_id = $1
+		UNION SELECT event_id FROM tickets WHERE user_id = $1 AND status = 'active')`, userID)
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	writeCalendar(c, ical.Calendar{Name: "My Minab events", RefreshInterval: feedRefresh, Events: list}, "private, max-age=900")
+}
+
+// OrganizerCalendarHandler serves the public feed of an organizer's own
+// events.
+func OrganizerCalendarHandler(c *gin.Context) {
+	raw, ok := icsParam(c, "file")
+	if !ok {
+		return
+	}
+	organizerID, _ := strconv.Atoi(raw)
+
+	var name sql.NullString
+	err := utils.DB.QueryRow(`SELECT name FROM users WHERE id = $1 AND deleted_at IS NULL`, organizerID).Scan(&name)
+	isOrganizer := false
+	if err == nil {
+		isOrganizer, err = auth.IsOrganizer(organizerID)
+	}
+	if err == sql.ErrNoRows || (err == nil && !isOrganizer) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Organizer not found"})
+		return
+	} else if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	list, err := loadCalendarEvents(feedWindow+` AND e.user_id = $1`, organizerID)
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	title := "Events on Minab"
+	if name.String != "" {
+		title = name.String + " on Minab"
+	}
+	writeCalendar(c, ical.Calendar{Name: title, RefreshInterval: feedRefresh, Events: list}, "public, max-age=900")
+}
+
+// OrganizationCalendarHandler serves the public feed of an organization's
+// events, by ID or slug.
+func OrganizationCalendarHandler(c *gin.Context) {
+	raw, ok := icsParam(c, "file")
+	if !ok {
+		return
+	}
+
+	var orgID int
+	var name string
+	err := utils.DB.QueryRow(
+		`SELECT id, name FROM organizations WHERE id::text = $1 OR slug = $1`, raw,
+	).Scan(&orgID, &name)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Organization not found"})
+		return
+	} else if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	list, err := loadCalendarEvents(feedWindow+` AND e.organization_id = $1`, orgID)
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	writeCalendar(c, ical.Calendar{Name: name + " on Minab", RefreshInterval: feedRefresh, Events: list}, "public, max-age=900")
+}
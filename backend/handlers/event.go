@@ -1,124 +1,427 @@
 package handlers
 
 import (
+	"database/sql"
 	"fmt"
+	"local-event-backend/auth"
+	"local-event-backend/events"
+	"local-event-backend/recurrence"
 	"local-event-backend/utils"
 	"net/http"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/lib/pq"
 )
 
 type CreateEventRequest struct {
-	Input struct {
-		Title         string   `json:"title"`
-		Description   string   `json:"description"`
-		Date          string   `json:"date"`
-		Price         float64  `json:"price"`
-		LocationLat   float64  `json:"location_lat"`
-		LocationLng   float64  `json:"location_lng"`
-		VenueName     string   `json:"venue_name"`
-		Address       string   `json:"address"`
-		CategoryID    int      `json:"category_id"`
-		Tags          []string `json:"tags"`
-		ImageURLs     []string `json:"image_urls"`
-		FeaturedImage string   `json:"featured_image"`
-	} `json:"input"`
-	SessionVariables map[string]interface{} `json:"session_variables"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	EventScheduleInput
+	// Date is the old bare event date, read as an all-day event in
+	// time_zone when starts_at is absent.
+	Date          string   `json:"date"`
+	Price         float64  `json:"price"`
+	LocationLat   float64  `json:"location_lat"`
+	LocationLng   float64  `json:"location_lng"`
+	VenueName     string   `json:"venue_name"`
+	Address       string   `json:"address"`
+	CategoryID    int      `json:"category_id"`
+	Tags          []string `json:"tags"`
+	ImageURLs     []string `json:"image_urls"`
+	FeaturedImage string   `json:"featured_image"`
+	// OrganizationID makes the organization own the event instead of
+	// the caller alone.
+	OrganizationID *int `json:"organization_id"`
+	// Draft saves the event without publishing it. PublishAt (RFC 3339)
+	// also saves a draft, which the scheduler publishes at that time.
+	Draft     bool   `json:"draft"`
+	PublishAt string `json:"publish_at"`
+
+	EventRecurrenceInput
+
+	// TicketTypes are the kinds of ticket on sale. Without any, the event
+	// gets one General type at Price.
+	TicketTypes []TicketTypeInput `json:"ticket_types"`
+
+	// EventDate and Images are the names the event form posts directly.
+	EventDate string   `json:"event_date"`
+	Images    []string `json:"images"`
 }
 
 type EventResponse struct {
-	ID      int    `json:"id"`
-	Message string `json:"message"`
+	ID          int            `json:"id"`
+	Message     string         `json:"message"`
+	Schedule    *EventSchedule `json:"schedule,omitempty"`
+	Occurrences int            `json:"occurrences,omitempty"`
+}
+
+const maxEventTitleLength = 200
+
+// checkEventTitle trims title and records an error when it is empty or too
+// long.
+func checkEventTitle(errs *fieldErrors, title string) string {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		errs.add("title", "required", "Title is required")
+	} else if len(title) > maxEventTitleLength {
+		errs.add("title", "too_long", fmt.Sprintf("Title must be at most %d characters", maxEventTitleLength))
+	}
+	return title
+}
+
+// EventScheduleInput is when an event happens. Times are RFC 3339, or
+// wall-clock times such as 2026-01-31T18:30 read in time_zone. A bare date
+// in starts_at makes an all-day event.
+type EventScheduleInput struct {
+	StartsAt    string `json:"starts_at"`
+	EndsAt      string `json:"ends_at"`
+	DoorsOpenAt string `json:"doors_open_at"`
+	TimeZone    string `json:"time_zone"`
+}
+
+// EventSchedule renders a schedule in the event's own zone.
+type EventSchedule struct {
+	StartsAt    string `json:"starts_at"`
+	EndsAt      string `json:"ends_at"`
+	DoorsOpenAt string `json:"doors_open_at,omitempty"`
+	TimeZone    string `json:"time_zone"`
+	MultiDay    bool   `json:"multi_day"`
+}
+
+func renderSchedule(s events.Schedule) *EventSchedule {
+	out := &EventSchedule{
+		StartsAt: s.StartsAt.In(s.Location).Format(time.RFC3339),
+		EndsAt:   s.EndsAt.In(s.Location).Format(time.RFC3339),
+		TimeZone: s.Location.String(),
+		MultiDay: s.MultiDay(),
+	}
+	if s.DoorsOpenAt != nil {
+		out.DoorsOpenAt = s.DoorsOpenAt.In(s.Location).Format(time.RFC3339)
+	}
+	return out
+}
+
+// maxDoorsLead is how long before the start doors may open.
+const maxDoorsLead = 24 * time.Hour
+
+// checkEventSchedule builds a schedule from in. current is the stored
+// schedule when updating, or nil when creating: fields missing from in keep
+// their current values, and moving the start shifts the end and doors-open
+// time by the same amount. legacyDate is the old bare date field, used when
+// starts_at is absent. A start in the past is only rejected when it changed.
+func checkEventSchedule(errs *fieldErrors, in EventScheduleInput, legacyDate string, current *events.Schedule) events.Schedule {
+	var out events.Schedule
+	before := len(*errs)
+
+	zone := in.TimeZone
+	if zone == "" && current != nil {
+		zone = current.Location.String()
+	}
+	loc, err := events.LoadLocation(zone)
+	if err != nil {
+		errs.add("time_zone", "invalid_time_zone", "Time zone must be an IANA name such as Africa/Addis_Ababa")
+		return out
+	}
+	out.Location = loc
+
+	startField, startInput := "starts_at", in.StartsAt
+	if startInput == "" && legacyDate != "" {
+		startField, startInput = "date", legacyDate
+	}
+	allDay := false
+	switch {
+	case startInput != "":
+		out.StartsAt, allDay, err = events.ParseTime(startInput, loc)
+		if err != nil {
+			errs.add(startField, "invalid_datetime", "Start must look like 2026-01-31T18:30")
+			return out
+		}
+	case current != nil:
+		// Re-read the stored wall-clock time in the (possibly new) zone.
+		wall := current.StartsAt.In(current.Location)
+		out.StartsAt = time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, loc)
+	default:
+		errs.add("starts_at", "required", "Start time is required")
+		return out
+	}
+
+	switch {
+	case in.EndsAt != "":
+		out.EndsAt, _, err = events.ParseTime(in.EndsAt, loc)
+		if err != nil {
+			errs.add("ends_at", "invalid_datetime", "End must look like 2026-01-31T22:00")
+		}
+	case allDay:
+		out.EndsAt = out.StartsAt.AddDate(0, 0, 1)
+	case current != nil:
+		out.EndsAt = out.StartsAt.Add(current.EndsAt.Sub(current.StartsAt))
+	default:
+		errs.add("ends_at", "required", "End time is required")
+	}
+
+	switch {
+	case in.DoorsOpenAt != "":
+		doors, _, err := events.ParseTime(in.DoorsOpenAt, loc)
+		if err != nil {
+			errs.add("doors_open_at", "invalid_datetime", "Doors-open time must look like 2026-01-31T17:30")
+		}
+		out.DoorsOpenAt = &doors
+	case current != nil && current.DoorsOpenAt != nil:
+		doors := out.StartsAt.Add(current.DoorsOpenAt.Sub(current.StartsAt))
+		out.DoorsOpenAt = &doors
+	}
+	if len(*errs) > before {
+		return out
+	}
+
+	if current == nil || !out.StartsAt.Equal(current.StartsAt) {
+		if out.StartsAt.Before(time.Now()) && !(allDay && out.LocalDate() == time.Now().In(loc).Format("2006-01-02")) {
+			errs.add(startField, "datetime_in_past", "Start cannot be in the past")
+		}
+	}
+	if !out.EndsAt.After(out.StartsAt) {
+		errs.add("ends_at", "ends_before_start", "End must be after the start")
+	} else if out.EndsAt.Sub(out.StartsAt) > events.MaxDuration {
+		errs.add("ends_at", "too_long", "Events can last at most 31 days")
+	}
+	if d := out.DoorsOpenAt; d != nil && (d.After(out.StartsAt) || out.StartsAt.Sub(*d) > maxDoorsLead) {
+		errs.add("doors_open_at", "invalid_doors_open", "Doors must open within 24 hours before the start")
+	}
+	return out
+}
+
+// EventRecurrenceInput makes an event repeat. RRule is an RFC 5545 RRULE
+// whose first instance is starts_at; ExDates are instances to skip, in the
+// same formats as starts_at. OccurrenceCapacity is the number of tickets
+// each occurrence can sell; nil means unlimited.
+type EventRecurrenceInput struct {
+	RRule              string   `json:"rrule"`
+	ExDates            []string `json:"exdates"`
+	OccurrenceCapacity *int     `json:"occurrence_capacity"`
+}
+
+// checkEventRecurrence parses the rule and exception dates in loc. An empty
+// rule returns nil.
+func checkEventRecurrence(errs *fieldErrors, in EventRecurrenceInput, loc *time.Location) (*recurrence.Rule, []time.Time) {
+	if in.OccurrenceCapacity != nil && *in.OccurrenceCapacity < 0 {
+		errs.add("occurrence_capacity", "negative_capacity", "Capacity cannot be negative")
+	}
+	if strings.TrimSpace(in.RRule) == "" {
+		if len(in.ExDates) > 0 {
+			errs.add("exdates", "requires_rrule", "Exception dates need a recurrence rule")
+		}
+		return nil, nil
+	}
+	rule, err := recurrence.ParseInLocation(in.RRule, loc)
+	if err != nil {
+		errs.add("rrule", "invalid_rrule", "Recurrence rule is invalid: "+err.Error())
+		return nil, nil
+	}
+	return rule, checkExDates(errs, in.ExDates, loc)
+}
+
+func checkExDates(errs *fieldErrors, in []string, loc *time.Location) []time.Time {
+	exdates := []time.Time{}
+	for _, s := range in {
+		t, _, err := events.ParseTime(s, loc)
+		if err != nil {
+			errs.add("exdates", "invalid_datetime", "Exception dates must look like 2026-01-31T18:30")
+			return nil
+		}
+		exdates = append(exdates, t)
+	}
+	return exdates
+}
+
+// ruleString is what is stored in events.rrule: the canonical rule, or NULL.
+func ruleString(rule *recurrence.Rule) interface{} {
+	if rule == nil {
+		return nil
+	}
+	return rule.String()
+}
+
+// countOccurrences reports how many upcoming occurrences eventID has.
+func countOccurrences(tx *sql.Tx, eventID int) (int, error) {
+	var n int
+	err := tx.QueryRow(
+		`SELECT COUNT(*) FROM event_occurrences WHERE event_id = $1 AND status = 'scheduled' AND starts_at > NOW()`,
+		eventID,
+	).Scan(&n)
+	return n, err
+}
+
+func checkEventPrice(errs *fieldErrors, price float64) {
+	if price < 0 {
+		errs.add("price", "negative_price", "Price cannot be negative")
+	}
+}
+
+func checkEventLocation(errs *fieldErrors, lat, lng *float64) {
+	if lat != nil && (*lat < -90 || *lat > 90) {
+		errs.add("location_lat", "out_of_range", "Latitude must be between -90 and 90")
+	}
+	if lng != nil && (*lng < -180 || *lng > 180) {
+		errs.add("location_lng", "out_of_range", "Longitude must be between -180 and 180")
+	}
+}
+
+// checkEventCategory records an error unless categoryID names an existing
+// category.
+func checkEventCategory(errs *fieldErrors, categoryID int) error {
+	if categoryID <= 0 {
+		errs.add("category_id", "required", "Category is required")
+		return nil
+	}
+	var exists bool
+	if err := utils.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1)`, categoryID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		errs.add("category_id", "unknown_category", "Category does not exist")
+	}
+	return nil
 }
 
 func CreateEventHandler(c *gin.Context) {
 	var req CreateEventRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := bindInput(c, &req); err != nil {
 		fmt.Println("❌ JSON Bind Error:", err)
 		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid JSON payload"})
 		return
 	}
 
-	var userIDStr string
-
-	if req.SessionVariables != nil {
-		if val, exists := req.SessionVariables["x-hasura-user-id"]; exists {
-			userIDStr = fmt.Sprintf("%v", val)
-		}
+	userIDInt, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	if orgID := req.OrganizationID; orgID != nil && !requireOrgPermission(c, userIDInt, *orgID, auth.PermManageEvents) {
+		return
 	}
+	fmt.Printf("✅ Authorized: Creating event for User ID %d\n", userIDInt)
 
-	if userIDStr == "" {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader != "" {
-			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-			token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
-			if err == nil {
-				if claims, ok := token.Claims.(jwt.MapClaims); ok {
-					// Check common Hasura claim paths
-					if hasuraClaims, ok := claims["https://hasura.io/jwt/claims"].(map[string]interface{}); ok {
-						userIDStr = fmt.Sprintf("%v", hasuraClaims["x-hasura-user-id"])
-					} else if sub, ok := claims["sub"].(string); ok {
-						userIDStr = sub
-					}
-				}
-			}
-		}
+	if req.Date == "" {
+		req.Date = req.EventDate
+	}
+	if len(req.ImageURLs) == 0 {
+		req.ImageURLs = req.Images
+	}
+	if req.FeaturedImage == "" && len(req.ImageURLs) > 0 {
+		req.FeaturedImage = req.ImageURLs[0]
 	}
 
-	if userIDStr == "" || userIDStr == "<nil>" {
-		fmt.Println("❌ Auth Error: No User ID found in SessionVariables or Token")
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "Please log in again. Your identity could not be verified."})
+	var errs fieldErrors
+	title := checkEventTitle(&errs, req.Title)
+	schedule := checkEventSchedule(&errs, req.EventScheduleInput, req.Date, nil)
+	var rule *recurrence.Rule
+	var exdates []time.Time
+	var ticketTypes []ticketType
+	if schedule.Location != nil {
+		rule, exdates = checkEventRecurrence(&errs, req.EventRecurrenceInput, schedule.Location)
+		ticketTypes = checkTicketTypes(&errs, req.TicketTypes, req.Price, schedule.Location)
+	}
+	checkEventPrice(&errs, req.Price)
+	checkEventLocation(&errs, &req.LocationLat, &req.LocationLng)
+	status := events.Published
+	var publishAt *time.Time
+	if req.PublishAt != "" {
+		publishAt = checkPublishAt(&errs, req.PublishAt)
+		status = events.Draft
+	} else if req.Draft {
+		status = events.Draft
+	}
+	if err := checkEventCategory(&errs, req.CategoryID); err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	if len(errs) > 0 {
+		respondFieldErrors(c, http.StatusBadRequest, errs)
 		return
 	}
 
-	userIDInt, _ := strconv.Atoi(userIDStr)
-	fmt.Printf("✅ Authorized: Creating event for User ID %d\n", userIDInt)
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
 
 	var eventID int
 	query := `
-		INSERT INTO events 
-		(title, description, event_date, price, location_lat, location_lng, venue_name, address, category_id, user_id, featured_image, event_images)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		INSERT INTO events
+		(title, description, event_date, price, location_lat, location_lng, venue_name, address, category_id, user_id, featured_image, organization_id,
+		 status, publish_at, published_at, starts_at, ends_at, doors_open_at, time_zone,
+		 rrule, exdates, occurrence_capacity)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''), $12, $13, $14, CASE WHEN $13 = 'published' THEN NOW() END, $15, $16, $17, $18,
+		        $19, $20, $21)
 		RETURNING id`
-	
-	err := utils.DB.QueryRow(query, 
-		req.Input.Title, 
-		req.Input.Description, 
-		req.Input.Date, 
-		req.Input.Price,
-		req.Input.LocationLat, 
-		req.Input.LocationLng, 
-		req.Input.VenueName, 
-		req.Input.Address,
-		req.Input.CategoryID, 
-		userIDInt, 
-		req.Input.FeaturedImage, 
-		pq.Array(req.Input.ImageURLs),
-	).Scan(&eventID)
 
+	err = tx.QueryRow(query,
+		title,
+		req.Description,
+		schedule.LocalDate(),
+		req.Price,
+		req.LocationLat,
+		req.LocationLng,
+		strings.TrimSpace(req.VenueName),
+		strings.TrimSpace(req.Address),
+		req.CategoryID,
+		userIDInt,
+		req.FeaturedImage,
+		req.OrganizationID,
+		string(status),
+		publishAt,
+		schedule.StartsAt,
+		schedule.EndsAt,
+		schedule.DoorsOpenAt,
+		schedule.Location.String(),
+		ruleString(rule),
+		events.TimeArray(exdates),
+		req.OccurrenceCapacity,
+	).Scan(&eventID)
+	if err == nil {
+		err = replaceEventTags(tx, eventID, req.Tags)
+	}
+	if err == nil {
+		err = replaceEventImages(tx, eventID, req.ImageURLs)
+	}
+	for i, tt := range ticketTypes {
+		if err != nil {
+			break
+		}
+		_, err = insertTicketType(tx, eventID, tt, i)
+	}
+	if err == nil {
+		err = refreshEventPrice(tx, eventID)
+	}
+	var occurrences int
+	if err == nil && rule != nil {
+		if err = events.SyncOccurrences(tx, eventID); err == nil {
+			occurrences, err = countOccurrences(tx, eventID)
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		fmt.Println("❌ SQL Insert Error:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "Database error: " + err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not create event"})
 		return
 	}
 
-	for _, tag := range req.Input.Tags {
-		if tag == "" || tag == "General" { continue }
-		_, err := utils.DB.Exec("INSERT INTO event_tags (event_id, tag_name) VALUES ($1, $2)", eventID, tag)
-		if err != nil {
-			fmt.Println("⚠️ Tag Insert Warning:", err)
-		}
-	}
+	fmt.Printf("🎉 Success! Event %d saved as %s by User %d\n", eventID, status, userIDInt)
 
-	fmt.Printf("🎉 Success! Event %d published by User %d\n", eventID, userIDInt)
-	
-	
+	message := "Event created successfully"
+	if status == events.Draft {
+		message = "Event saved as draft"
+	}
 	c.JSON(http.StatusOK, EventResponse{
-		ID:      eventID,
-		Message: "Event created successfully",
+		ID:          eventID,
+		Message:     message,
+		Schedule:    renderSchedule(schedule),
+		Occurrences: occurrences,
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"local-event-backend/auth"
+	"local-event-backend/events"
+	"local-event-backend/utils"
+)
+
+// UpdateEventRequest changes only the fields that are present. Tags and
+// image_urls replace the whole list when given. Price is only read to be
+// refused: prices belong to the event's ticket types.
+type UpdateEventRequest struct {
+	EventID     int     `json:"event_id"`
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	EventScheduleInput
+	Date          string    `json:"date"`
+	Price         *float64  `json:"price"`
+	LocationLat   *float64  `json:"location_lat"`
+	LocationLng   *float64  `json:"location_lng"`
+	VenueName     *string   `json:"venue_name"`
+	Address       *string   `json:"address"`
+	CategoryID    *int      `json:"category_id"`
+	Tags          *[]string `json:"tags"`
+	ImageURLs     *[]string `json:"image_urls"`
+	FeaturedImage *string   `json:"featured_image"`
+	// RRule replaces the recurrence rule; an empty string stops the event
+	// repeating. ExDates replaces the skipped instances.
+	RRule              *string   `json:"rrule"`
+	ExDates            *[]string `json:"exdates"`
+	OccurrenceCapacity *int      `json:"occurrence_capacity"`
+}
+
+type CancelEventRequest struct {
+	EventID int    `json:"event_id"`
+	Reason  string `json:"reason"`
+}
+
+type DeleteEventRequest struct {
+	EventID int `json:"event_id"`
+}
+
+// requireEventPermission answers 404 or 403 and returns false unless the
+// caller holds perm for eventID.
+func requireEventPermission(c *gin.Context, userID, eventID int, perm auth.Permission) bool {
+	allowed, err := auth.CanOnEvent(userID, eventID, perm)
+	if err == auth.ErrEventNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Event not found"})
+		return false
+	} else if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"message": "You do not have permission to manage this event"})
+		return false
+	}
+	return true
+}
+
+// replaceEventTags swaps the event's tags for tags, skipping blanks, the
+// "General" placeholder and case-insensitive duplicates.
+func replaceEventTags(tx *sql.Tx, eventID int, tags []string) error {
+	if _, err := tx.Exec(`DELETE FROM event_tags WHERE event_id = $1`, eventID); err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		key := strings.ToLower(tag)
+		if tag == "" || tag == "General" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		if _, err := tx.Exec(`INSERT INTO event_tags (event_id, name) VALUES ($1, $2)`, eventID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceEventImages swaps the event's gallery for urls, in order.
+func replaceEventImages(tx *sql.Tx, eventID int, urls []string) error {
+	if _, err := tx.Exec(`DELETE FROM event_images WHERE event_id = $1`, eventID); err != nil {
+		return err
+	}
+	for _, u := range urls {
+		u = strings.TrimSpace(u)
+		if u == "" {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO event_images (event_id, image_url) VALUES ($1, $2)`, eventID, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func UpdateEventHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateEventRequest
+	if err := bindInput(c, &req); err != nil || req.EventID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "event_id is required"})
+		return
+	}
+	if !requireEventPermission(c, userID, req.EventID, auth.PermManageEvents) {
+		return
+	}
+
+	var sets []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	var errs fieldErrors
+	if req.Title != nil {
+		set("title", checkEventTitle(&errs, *req.Title))
+	}
+	if req.Description != nil {
+		set("description", *req.Description)
+	}
+	if req.Price != nil {
+		errs.add("price", "use_ticket_types", "Prices are set per ticket type; change them with /update-ticket-type")
+	}
+	checkEventLocation(&errs, req.LocationLat, req.LocationLng)
+	if req.LocationLat != nil {
+		set("location_lat", *req.LocationLat)
+	}
+	if req.LocationLng != nil {
+		set("location_lng", *req.LocationLng)
+	}
+	if req.VenueName != nil {
+		set("venue_name", *req.VenueName)
+	}
+	if req.Address != nil {
+		set("address", *req.Address)
+	}
+	if req.CategoryID != nil {
+		if err := checkEventCategory(&errs, *req.CategoryID); err != nil {
+			fmt.Println("❌ DB ERROR:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+			return
+		}
+		set("category_id", *req.CategoryID)
+	}
+	if req.FeaturedImage != nil {
+		set("featured_image", *req.FeaturedImage)
+	}
+	if len(errs) > 0 {
+		respondFieldErrors(c, http.StatusBadRequest, errs)
+		return
+	}
+
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the row so a concurrent cancel cannot slip in between the status
+	// check and the update.
+	var status string
+	if err := tx.QueryRow(`SELECT status FROM events WHERE id = $1 FOR UPDATE`, req.EventID).Scan(&status); err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	if st := events.Status(status); st == events.Cancelled || st == events.Completed {
+		c.JSON(http.StatusConflict, gin.H{"message": fmt.Sprintf("A %s event cannot be edited", st)})
+		return
+	}
+
+	var schedule *EventSchedule
+	scheduleChanged := req.EventScheduleInput != (EventScheduleInput{}) || req.Date != ""
+	recurrenceChanged := req.RRule != nil || req.ExDates != nil || req.OccurrenceCapacity != nil
+	if scheduleChanged || recurrenceChanged {
+		current, err := events.LoadSchedule(tx, req.EventID)
+		if err != nil {
+			fmt.Println("❌ DB ERROR:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+			return
+		}
+		var errs fieldErrors
+		loc := current.Location
+		if scheduleChanged {
+			next := checkEventSchedule(&errs, req.EventScheduleInput, req.Date, &current)
+			if len(errs) == 0 {
+				set("starts_at", next.StartsAt)
+				set("ends_at", next.EndsAt)
+				set("doors_open_at", next.DoorsOpenAt)
+				set("time_zone", next.Location.String())
+				set("event_date", next.LocalDate())
+				schedule = renderSchedule(next)
+				loc = next.Location
+			}
+		}
+		if req.RRule != nil {
+			rule, _ := checkEventRecurrence(&errs, EventRecurrenceInput{RRule: *req.RRule}, loc)
+			set("rrule", ruleString(rule))
+		}
+		if req.ExDates != nil {
+			set("exdates", events.TimeArray(checkExDates(&errs, *req.ExDates, loc)))
+		}
+		if req.OccurrenceCapacity != nil {
+			checkEventRecurrence(&errs, EventRecurrenceInput{OccurrenceCapacity: req.OccurrenceCapacity}, loc)
+			set("occurrence_capacity", *req.OccurrenceCapacity)
+		}
+		if len(errs) > 0 {
+			respondFieldErrors(c, http.StatusBadRequest, errs)
+			return
+		}
+	}
+
+	if len(sets) > 0 {
+		args = append(args, req.EventID)
+		query := fmt.Sprintf(`UPDATE events SET %s WHERE id = $%d`, strings.Join(sets, ", "), len(args))
+		_, err = tx.Exec(query, args...)
+	}
+	if err == nil && req.Tags != nil {
+		err = replaceEventTags(tx, req.EventID, *req.Tags)
+	}
+	if err == nil && req.ImageURLs != nil {
+		err = replaceEventImages(tx, req.EventID, *req.ImageURLs)
+	}
+	if err == nil && (scheduleChanged || recurrenceChanged) {
+		err = events.SyncOccurrences(tx, req.EventID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fmt.Println("❌ SQL Update Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not update event"})
+		return
+	}
+
+	fmt.Printf("✏️ Event %d updated by User %d\n", req.EventID, userID)
+	c.JSON(http.StatusOK, EventResponse{ID: req.EventID, Message: "Event updated successfully", Schedule: schedule})
+}
+
+// CancelEventHandler marks the event cancelled. Its tickets and sales are
+// kept so buyers can still be refunded and see their history, and ticket
+// holders and followers are told.
+func CancelEventHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req CancelEventRequest
+	if err := bindInput(c, &req); err != nil || req.EventID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "event_id is required"})
+		return
+	}
+	if !requireEventPermission(c, userID, req.EventID, auth.PermManageEvents) {
+		return
+	}
+
+	reason := strings.TrimSpace(req.Reason)
+	ok = transitionEvent(c, req.EventID, events.Cancelled, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`UPDATE events SET cancellation_reason = NULLIF($2, '') WHERE id = $1`, req.EventID, reason)
+		return err
+	})
+	if !ok {
+		return
+	}
+
+	go notifyEventAudience(req.EventID, "has been cancelled", reason)
+
+	fmt.Printf("🚫 Event %d cancelled by User %d\n", req.EventID, userID)
+	c.JSON(http.StatusOK, EventResponse{ID: req.EventID, Message: "Event cancelled"})
+}
+
+// DeleteEventHandler removes an event that never sold anything. Events with
+// tickets or sales must be cancelled instead.
+func DeleteEventHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req DeleteEventRequest
+	if err := bindInput(c, &req); err != nil || req.EventID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "event_id is required"})
+		return
+	}
+	if !requireEventPermission(c, userID, req.EventID, auth.PermManageEvents) {
+		return
+	}
+
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var sold bool
+	_, err = tx.Exec(`SELECT 1 FROM events WHERE id = $1 FOR UPDATE`, req.EventID)
+	if err == nil {
+		err = tx.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM ticket_sales WHERE event_id = $1)
+			    OR EXISTS (SELECT 1 FROM tickets WHERE event_id = $1)`,
+			req.EventID,
+		).Scan(&sold)
+	}
+	if err == nil && sold {
+		c.JSON(http.StatusConflict, gin.H{"message": "This event has ticket sales; cancel it instead"})
+		return
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM events WHERE id = $1`, req.EventID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not delete event"})
+		return
+	}
+
+	fmt.Printf("🗑️ Event %d deleted by User %d\n", req.EventID, userID)
+	c.JSON(http.StatusOK, EventResponse{ID: req.EventID, Message: "Event deleted"})
+}
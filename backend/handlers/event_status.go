@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"local-event-backend/auth"
+	"local-event-backend/events"
+	"local-event-backend/mailer"
+	"local-event-backend/sms"
+	"local-event-backend/utils"
+)
+
+// PublishEventRequest publishes a draft or postponed event now, or, for a
+// draft, schedules it when publish_at is given.
+type PublishEventRequest struct {
+	EventID   int    `json:"event_id"`
+	PublishAt string `json:"publish_at"`
+}
+
+type PostponeEventRequest struct {
+	EventID int    `json:"event_id"`
+	Reason  string `json:"reason"`
+}
+
+// checkPublishAt parses an RFC 3339 publish time and requires it to be in
+// the future.
+func checkPublishAt(errs *fieldErrors, s string) *time.Time {
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(s))
+	if err != nil {
+		errs.add("publish_at", "invalid_datetime", "publish_at must be an RFC 3339 time such as 2026-01-31T18:00:00+03:00")
+		return nil
+	}
+	if !t.After(time.Now()) {
+		errs.add("publish_at", "datetime_in_past", "publish_at must be in the future")
+		return nil
+	}
+	return &t
+}
+
+// transitionEvent moves eventID to status inside one transaction, running
+// also with the same locked row, and answers the request on failure.
+func transitionEvent(c *gin.Context, eventID int, to events.Status, also func(*sql.Tx) error) bool {
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return false
+	}
+	defer tx.Rollback()
+
+	_, err = events.Transition(tx, eventID, to)
+	var terr *events.TransitionError
+	if errors.As(err, &terr) {
+		msg := fmt.Sprintf("A %s event cannot be %s", terr.From, terr.To)
+		if terr.From == terr.To {
+			msg = fmt.Sprintf("Event is already %s", terr.To)
+		}
+		c.JSON(http.StatusConflict, gin.H{"message": msg})
+		return false
+	} else if err == events.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Event not found"})
+		return false
+	}
+	if err == nil && also != nil {
+		err = also(tx)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return false
+	}
+	return true
+}
+
+func PublishEventHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req PublishEventRequest
+	if err := bindInput(c, &req); err != nil || req.EventID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "event_id is required"})
+		return
+	}
+	if !requireEventPermission(c, userID, req.EventID, auth.PermManageEvents) {
+		return
+	}
+
+	if req.PublishAt != "" {
+		var errs fieldErrors
+		publishAt := checkPublishAt(&errs, req.PublishAt)
+		if len(errs) > 0 {
+			respondFieldErrors(c, http.StatusBadRequest, errs)
+			return
+		}
+		res, err := utils.DB.Exec(`UPDATE events SET publish_at = $2 WHERE id = $1 AND status = $3`,
+			req.EventID, *publishAt, string(events.Draft))
+		if err != nil {
+			fmt.Println("❌ DB ERROR:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusConflict, gin.H{"message": "Only drafts can be scheduled"})
+			return
+		}
+		c.JSON(http.StatusOK, EventResponse{ID: req.EventID, Message: "Event scheduled for " + publishAt.Format(time.RFC3339)})
+		return
+	}
+
+	if !transitionEvent(c, req.EventID, events.Published, nil) {
+		return
+	}
+
+	fmt.Printf("📣 Event %d published by User %d\n", req.EventID, userID)
+	c.JSON(http.StatusOK, EventResponse{ID: req.EventID, Message: "Event published"})
+}
+
+// PostponeEventHandler pauses ticket sales until the organizer sets a new
+// date and publishes again. Ticket holders and followers are told.
+func PostponeEventHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req PostponeEventRequest
+	if err := bindInput(c, &req); err != nil || req.EventID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "event_id is required"})
+		return
+	}
+	if !requireEventPermission(c, userID, req.EventID, auth.PermManageEvents) {
+		return
+	}
+
+	reason := strings.TrimSpace(req.Reason)
+	ok = transitionEvent(c, req.EventID, events.Postponed, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`UPDATE events SET postponement_reason = NULLIF($2, '') WHERE id = $1`, req.EventID, reason)
+		return err
+	})
+	if !ok {
+		return
+	}
+
+	go notifyEventAudience(req.EventID, "has been postponed", reason)
+
+	fmt.Printf("⏸️ Event %d postponed by User %d\n", req.EventID, userID)
+	c.JSON(http.StatusOK, EventResponse{ID: req.EventID, Message: "Event postponed"})
+}
+
+// requireOnSale answers 409 and returns false unless eventID is in a status
+// that sells tickets.
+func requireOnSale(c *gin.Context, eventID int) bool {
+	var status string
+	err := utils.DB.QueryRow(`SELECT status FROM events WHERE id = $1`, eventID).Scan(&status)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Event not found"})
+		return false
+	} else if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return false
+	}
+	if !events.Status(status).SellsTickets() {
+		c.JSON(http.StatusConflict, gin.H{"message": "Tickets are not on sale for this event"})
+		return false
+	}
+	return true
+}
+
+// notifyEventAudience tells ticket holders and followers that the event
+// changed, by email and SMS as each has chosen in their notification
+// preferences. It runs after the response is sent, so failures are only
+// logged.
+func notifyEventAudience(eventID int, what, reason string) {
+	var title string
+	if err := utils.DB.QueryRow(`SELECT title FROM events WHERE id = $1`, eventID).Scan(&title); err != nil {
+		fmt.Println("❌ Notify Error:", err)
+		return
+	}
+
+	rows, err := utils.DB.Query(`
+		SELECT u.email, u.phone_number,
+		       COALESCE((u.notification_preferences->>'email')::boolean, true),
+		       COALESCE((u.notification_preferences->>'sms')::boolean, false)
+		FROM users u
+		WHERE u.deleted_at IS NULL
+		  AND COALESCE((u.notification_preferences->>'event_updates')::boolean, true)
+		  AND u.id IN (
+		    SELECT user_id FROM event_followers WHERE event_id = $1
+		    UNION SELECT user_id FROM tickets WHERE event_id = $1
+		    UNION SELECT user_id FROM ticket_sales WHERE event_id = $1 AND status = 'completed'
+		  )`, eventID)
+	if err != nil {
+		fmt.Println("❌ Notify Error:", err)
+		return
+	}
+	defer rows.Close()
+
+	subject := fmt.Sprintf("%s %s", title, what)
+	body := fmt.Sprintf("%s %s.", title, what)
+	if reason != "" {
+		body += "\n\n" + reason
+	}
+	body += fmt.Sprintf("\n\nSee the event: %s/events/%d", appURL(), eventID)
+
+	sent := 0
+	for rows.Next() {
+		var email string
+		var phone sql.NullString
+		var byEmail, bySMS bool
+		if err := rows.Scan(&email, &phone, &byEmail, &bySMS); err != nil {
+			fmt.Println("❌ Notify Error:", err)
+			return
+		}
+		if byEmail {
+			if err := mailer.Default.Send(mailer.Message{To: email, Subject: subject, Body: body}); err != nil {
+				fmt.Println("⚠️ Notify Mail Warning:", err)
+			}
+		}
+		if bySMS && phone.Valid {
+			if err := sms.Default.SendSMS(phone.String, subject+". "+appURL()+fmt.Sprintf("/events/%d", eventID)); err != nil {
+				fmt.Println("⚠️ Notify SMS Warning:", err)
+			}
+		}
+		sent++
+	}
+	fmt.Printf("📨 Notified %d people that event %d %s\n", sent, eventID, what)
+}
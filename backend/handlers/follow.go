@@ -22,13 +22,13 @@ type FollowEventResponse struct {
 
 
 func FollowEventHandler(c *gin.Context) {
-	userID, ok := getUserIDFromAuthHeader(c)
+	userID, ok := currentUserID(c)
 	if !ok {
 		return
 	}
 
 	var req FollowEventRequest
-	if err := c.ShouldBindJSON(&req); err != nil || req.EventID == 0 {
+	if err := bindInput(c, &req); err != nil || req.EventID == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
 		return
 	}
@@ -56,13 +56,13 @@ func FollowEventHandler(c *gin.Context) {
 
 
 func UnfollowEventHandler(c *gin.Context) {
-	userID, ok := getUserIDFromAuthHeader(c)
+	userID, ok := currentUserID(c)
 	if !ok {
 		return
 	}
 
 	var req FollowEventRequest
-	if err := c.ShouldBindJSON(&req); err != nil || req.EventID == 0 {
+	if err := bindInput(c, &req); err != nil || req.EventID == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
 		return
 	}
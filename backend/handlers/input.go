@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// bindInput decodes the request body into dst. Hasura actions wrap their
+// arguments in an "input" object while plain REST calls send them at the top
+// level, so the same handler can be mounted under both kinds of route.
+func bindInput(c *gin.Context, dst interface{}) error {
+	raw, err := c.GetRawData()
+	if err != nil {
+		return err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(raw))
+
+	var envelope struct {
+		Input json.RawMessage `json:"input"`
+	}
+	if err := json.Unmarshal(raw, &envelope); err == nil && len(envelope.Input) > 0 {
+		raw = envelope.Input
+	}
+	return binding.JSON.BindBody(raw, dst)
+}
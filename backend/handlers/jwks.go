@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"local-event-backend/utils"
+)
+
+// JWKSHandler publishes the public signing keys so Hasura can verify tokens
+// through jwk_url instead of a shared secret.
+func JWKSHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, utils.Keys.JWKS())
+}
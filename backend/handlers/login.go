@@ -3,8 +3,13 @@ package handlers
 import (
 	"database/sql"
 	"fmt"
+	"local-event-backend/auth"
 	"local-event-backend/utils"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
@@ -19,9 +24,13 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token   string `json:"token"`
-	UserID  int    `json:"user_id"`
-	Message string `json:"message"` 
+	Token        string `json:"token"`
+	MfaRequired  bool   `json:"mfa_required,omitempty"`
+	MfaToken     string `json:"mfa_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in,omitempty"`
+	UserID       int    `json:"user_id"`
+	Message      string `json:"message"`
 }
 
 func LoginHandler(c *gin.Context) {
@@ -35,7 +44,7 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
-	email := req.Input.Email
+	email := strings.ToLower(strings.TrimSpace(req.Input.Email))
 	password := req.Input.Password
 
 	if email == "" || password == "" {
@@ -45,16 +54,31 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
+	ip := c.ClientIP()
+	wait, err := auth.Logins.Attempt(email, ip)
+	if err != nil {
+		fmt.Println("❌ Login Throttle Error:", err)
+		c.JSON(http.StatusInternalServerError, LoginResponse{
+			Message: "Internal server error",
+		})
+		return
+	}
+	if wait > 0 {
+		recordLoginAttempt(c, email, 0, false, "throttled")
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, LoginResponse{
+			Message: fmt.Sprintf("Too many failed login attempts. Try again in %s.", wait.Round(time.Second)),
+		})
+		return
+	}
+
 	var storedHash string
 	var userID int
 
-	err := utils.DB.QueryRow("SELECT id, password FROM users WHERE email = $1", email).Scan(&userID, &storedHash)
+	err = utils.DB.QueryRow("SELECT id, password FROM users WHERE lower(email) = $1", email).Scan(&userID, &storedHash)
 
 	if err == sql.ErrNoRows {
-		fmt.Printf("❌ Login Failed: User %s not found\n", email)
-		c.JSON(http.StatusUnauthorized, LoginResponse{
-			Message: "Invalid email or password",
-		})
+		loginFailed(c, email, 0, "unknown_email")
 		return
 	} else if err != nil {
 		fmt.Println("❌ Database Error during login:", err)
@@ -66,14 +90,58 @@ func LoginHandler(c *gin.Context) {
 
 	err = bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(password))
 	if err != nil {
-		fmt.Printf("❌ Login Failed: Incorrect password for %s\n", email)
-		c.JSON(http.StatusUnauthorized, LoginResponse{
-			Message: "Invalid email or password",
+		loginFailed(c, email, userID, "wrong_password")
+		return
+	}
+
+	finishPrimaryLogin(c, email, userID, req.Input.RememberMe)
+}
+
+// finishPrimaryLogin runs once the first factor (password or SMS code) is
+// verified: users with two-factor authentication get an mfa_token to
+// exchange at /login/mfa, everyone else gets a session straight away.
+func finishPrimaryLogin(c *gin.Context, email string, userID int, rememberMe bool) {
+	mfaEnabled, err := auth.MFAEnabled(userID)
+	if err != nil {
+		fmt.Println("❌ Database Error during login:", err)
+		c.JSON(http.StatusInternalServerError, LoginResponse{
+			Message: "Internal server error",
+		})
+		return
+	}
+	if !mfaEnabled {
+		completeLogin(c, email, userID, rememberMe)
+		return
+	}
+
+	// The throttle counters stay until the second factor succeeds, so
+	// knowing the first factor does not allow unlimited code guesses.
+	mfaToken, err := auth.NewMFAPendingToken(userID, rememberMe)
+	if err != nil {
+		fmt.Println("❌ Token Generation Failed:", err)
+		c.JSON(http.StatusInternalServerError, LoginResponse{
+			Message: "Failed to generate security token",
 		})
 		return
 	}
+	recordLoginAttempt(c, email, userID, false, "mfa_pending")
+	c.JSON(http.StatusOK, LoginResponse{
+		MfaRequired: true,
+		MfaToken:    mfaToken,
+		UserID:      userID,
+		Message:     "Enter the code from your authenticator app",
+	})
+}
+
+// completeLogin clears the throttle counters, audits the success and
+// starts a session once every factor has been checked.
+func completeLogin(c *gin.Context, email string, userID int, rememberMe bool) {
+	if err := auth.Logins.Succeeded(email, c.ClientIP()); err != nil {
+		fmt.Println("❌ Login Throttle Error:", err)
+	}
+	recordLoginAttempt(c, email, userID, true, "")
 
-	token, err := utils.GenerateToken(userID)
+	tokens, err := auth.StartSession(userID, rememberMe, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
 		fmt.Println("❌ Token Generation Failed:", err)
 		c.JSON(http.StatusInternalServerError, LoginResponse{
@@ -82,10 +150,28 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
-	fmt.Printf("✅ User %s logged in successfully (ID: %d)\n", email, userID)
+	fmt.Printf("✅ User %d logged in successfully\n", userID)
 	c.JSON(http.StatusOK, LoginResponse{
-		Token:   token,
-		UserID:  userID,
-		Message: "Login successful",
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+		UserID:       userID,
+		Message:      "Login successful",
+	})
+}
+
+// loginFailed audits a failure, which already counts towards throttling
+// from the reservation, and answers with the same message whether the email
+// or the password was wrong.
+func loginFailed(c *gin.Context, email string, userID int, reason string) {
+	recordLoginAttempt(c, email, userID, false, reason)
+	c.JSON(http.StatusUnauthorized, LoginResponse{
+		Message: "Invalid email or password",
 	})
-}
\ No newline at end of file
+}
+
+func recordLoginAttempt(c *gin.Context, email string, userID int, success bool, reason string) {
+	if err := auth.RecordLoginAttempt(email, userID, c.ClientIP(), c.Request.UserAgent(), success, reason); err != nil {
+		fmt.Println("❌ Login Audit Error:", err)
+	}
+}
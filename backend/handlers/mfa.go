@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"local-event-backend/auth"
+	"local-event-backend/utils"
+)
+
+type LoginMFARequest struct {
+	MfaToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+type MFADisableRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type MFAEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+	Message    string `json:"message"`
+}
+
+type MFAActivateResponse struct {
+	BackupCodes []string `json:"backup_codes"`
+	Message     string   `json:"message"`
+}
+
+// LoginMFAHandler is the second login step: it trades the mfa_token from
+// LoginHandler plus a TOTP or backup code for a session.
+func LoginMFAHandler(c *gin.Context) {
+	var req LoginMFARequest
+	if err := bindInput(c, &req); err != nil || req.MfaToken == "" || req.Code == "" {
+		c.JSON(http.StatusBadRequest, LoginResponse{Message: "mfa_token and code are required"})
+		return
+	}
+
+	userID, rememberMe, err := auth.ParseMFAPendingToken(req.MfaToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, LoginResponse{Message: "Your login has expired, please sign in again"})
+		return
+	}
+
+	var email string
+	if err := utils.DB.QueryRow(`SELECT email FROM users WHERE id = $1`, userID).Scan(&email); err != nil {
+		fmt.Println("❌ Database Error during login:", err)
+		c.JSON(http.StatusInternalServerError, LoginResponse{Message: "Internal server error"})
+		return
+	}
+
+	wait, err := auth.Logins.Attempt(email, c.ClientIP())
+	if err != nil {
+		fmt.Println("❌ Login Throttle Error:", err)
+		c.JSON(http.StatusInternalServerError, LoginResponse{Message: "Internal server error"})
+		return
+	}
+	if wait > 0 {
+		recordLoginAttempt(c, email, userID, false, "throttled")
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, LoginResponse{
+			Message: fmt.Sprintf("Too many failed login attempts. Try again in %s.", wait.Round(time.Second)),
+		})
+		return
+	}
+
+	err = auth.VerifyMFA(userID, req.Code)
+	if errors.Is(err, auth.ErrInvalidMFACode) || errors.Is(err, auth.ErrMFANotEnrolled) {
+		recordLoginAttempt(c, email, userID, false, "wrong_mfa_code")
+		c.JSON(http.StatusUnauthorized, LoginResponse{Message: "Invalid authentication code"})
+		return
+	} else if err != nil {
+		fmt.Println("❌ MFA Error:", err)
+		c.JSON(http.StatusInternalServerError, LoginResponse{Message: "Internal server error"})
+		return
+	}
+
+	completeLogin(c, email, userID, rememberMe)
+}
+
+func MFAEnrollHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var email string
+	if err := utils.DB.QueryRow(`SELECT email FROM users WHERE id = $1`, userID).Scan(&email); err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	secret, uri, err := auth.BeginTOTPEnrollment(userID, email)
+	if errors.Is(err, auth.ErrMFAAlreadyEnabled) {
+		c.JSON(http.StatusConflict, gin.H{"message": "Two-factor authentication is already enabled. Disable it first to set up a new authenticator."})
+		return
+	} else if err != nil {
+		fmt.Println("❌ MFA Enroll Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, MFAEnrollResponse{
+		Secret:     secret,
+		OtpauthURI: uri,
+		Message:    "Scan the QR code with your authenticator app, then confirm with a code",
+	})
+}
+
+func MFAActivateHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req MFACodeRequest
+	if err := bindInput(c, &req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "code is required"})
+		return
+	}
+
+	codes, err := auth.ActivateTOTP(userID, req.Code)
+	if errors.Is(err, auth.ErrMFAAlreadyEnabled) {
+		c.JSON(http.StatusConflict, gin.H{"message": "Two-factor authentication is already enabled"})
+		return
+	} else if errors.Is(err, auth.ErrMFANotEnrolled) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Start two-factor setup first"})
+		return
+	} else if errors.Is(err, auth.ErrInvalidMFACode) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid authentication code"})
+		return
+	} else if err != nil {
+		fmt.Println("❌ MFA Activate Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, MFAActivateResponse{
+		BackupCodes: codes,
+		Message:     "Two-factor authentication enabled. Store these backup codes somewhere safe.",
+	})
+}
+
+// MFADisableHandler turns two-factor off. It asks for both the password and
+// a current code so a hijacked session alone cannot remove the protection.
+func MFADisableHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req MFADisableRequest
+	if err := bindInput(c, &req); err != nil || req.Password == "" || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "password and code are required"})
+		return
+	}
+
+	var storedHash string
+	if err := utils.DB.QueryRow(`SELECT password FROM users WHERE id = $1`, userID).Scan(&storedHash); err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(req.Password)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Password is incorrect"})
+		return
+	}
+
+	err := auth.VerifyMFA(userID, req.Code)
+	if errors.Is(err, auth.ErrInvalidMFACode) || errors.Is(err, auth.ErrMFANotEnrolled) {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid authentication code"})
+		return
+	} else if err != nil {
+		fmt.Println("❌ MFA Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	if err := auth.DisableTOTP(userID); err != nil {
+		fmt.Println("❌ MFA Disable Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
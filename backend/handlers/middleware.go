@@ -1,57 +1,105 @@
 package handlers
 
 import (
-    "fmt"
-    "net/http"
-    "strings"
-    "local-event-backend/utils"
-    "github.com/gin-gonic/gin"
-    "github.com/golang-jwt/jwt/v5"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"local-event-backend/auth"
+	"local-event-backend/utils"
 )
 
+const principalKey = "principal"
+
+// AuthMiddleware resolves the caller with auth.Authenticate and stores the
+// resulting Principal in the context. Handlers read it back with
+// currentPrincipal.
 func AuthMiddleware() gin.HandlerFunc {
-    return func(c *gin.Context) {
-        authHeader := c.GetHeader("Authorization")
-        if authHeader == "" {
-            c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
-            c.Abort()
-            return
-        }
-
-        parts := strings.Split(authHeader, " ")
-        if len(parts) != 2 || parts[0] != "Bearer" {
-            c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization format"})
-            c.Abort()
-            return
-        }
-
-        tokenStr := parts[1]
-        token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-            if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-                return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-            }
-			return utils.JwtSecret, nil
-		})
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
+	return func(c *gin.Context) {
+		principal, err := auth.Authenticate(c.Request)
+		if err != nil {
+			fmt.Println("❌ Auth Error:", err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Please log in again. Your identity could not be verified."})
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			if hasuraClaims, ok := claims["https://hasura.io/jwt/claims"].(map[string]interface{}); ok {
-				if userID, ok := hasuraClaims["x-hasura-user-id"].(string); ok {
-					c.Set("userID", userID)
-					c.Next()
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// currentPrincipal returns the Principal stored by AuthMiddleware. It writes a
+// 401 and returns false when the route was not mounted behind the middleware.
+func currentPrincipal(c *gin.Context) (auth.Principal, bool) {
+	if v, ok := c.Get(principalKey); ok {
+		if principal, ok := v.(auth.Principal); ok {
+			return principal, true
+		}
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"message": "Please log in again. Your identity could not be verified."})
+	return auth.Principal{}, false
+}
+
+// currentUserID is a shorthand for handlers that only need the caller's ID.
+func currentUserID(c *gin.Context) (int, bool) {
+	principal, ok := currentPrincipal(c)
+	return principal.UserID, ok
+}
+
+// RequireRole lets the request through only when it runs as one of roles.
+// Organizer is checked again against the database, since a token can
+// outlive the organization membership it was issued for. Mount it after
+// AuthMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := currentPrincipal(c)
+		if !ok {
+			c.Abort()
+			return
+		}
+		for _, role := range roles {
+			if !principal.HasRole(role) {
+				continue
+			}
+			if role == auth.RoleOrganizer {
+				still, err := auth.IsOrganizer(principal.UserID)
+				if err != nil {
+					fmt.Println("❌ DB ERROR:", err)
+					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
 					return
 				}
+				if !still {
+					continue
+				}
 			}
-			
-			if userID, ok := claims["user_id"].(string); ok {
-				c.Set("userID", userID)
-				c.Next()
-				return
-			}
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "You do not have permission to perform this action"})
+	}
+}
+
+// RequireVerifiedEmail blocks callers who have not confirmed their email
+// address yet. Mount it after AuthMiddleware.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			c.Abort()
+			return
+		}
+
+		var verified bool
+		err := utils.DB.QueryRow(`SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1`, userID).Scan(&verified)
+		if err != nil {
+			fmt.Println("❌ DB ERROR:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+			return
+		}
+		if !verified {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Please verify your email address first"})
+			return
 		}
+		c.Next()
 	}
-}
\ No newline at end of file
+}
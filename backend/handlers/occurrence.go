@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"local-event-backend/auth"
+	"local-event-backend/events"
+	"local-event-backend/recurrence"
+	"local-event-backend/utils"
+)
+
+// Occurrence edit scopes, as calendar apps name them.
+const (
+	ScopeThis      = "this"
+	ScopeFollowing = "following"
+)
+
+// UpdateOccurrenceRequest edits one occurrence of a recurring event, or it
+// and every later one. Times use the same formats as /update-event and are
+// read in the event's zone; missing times keep their current values and a
+// moved start shifts the end and doors-open time with it.
+type UpdateOccurrenceRequest struct {
+	EventID      int    `json:"event_id"`
+	OccurrenceID int    `json:"occurrence_id"`
+	Scope        string `json:"scope"`
+	StartsAt     string `json:"starts_at"`
+	EndsAt       string `json:"ends_at"`
+	DoorsOpenAt  string `json:"doors_open_at"`
+	Capacity     *int   `json:"capacity"`
+	Cancel       bool   `json:"cancel"`
+	// RRule gives the following occurrences a new rule. Only used with
+	// scope "following".
+	RRule string `json:"rrule"`
+}
+
+type occurrenceRow struct {
+	ID               int
+	OriginalStartsAt time.Time
+	StartsAt         time.Time
+	EndsAt           time.Time
+	DoorsOpenAt      *time.Time
+	Status           string
+	TicketsSold      int
+}
+
+func loadOccurrence(tx *sql.Tx, eventID, occurrenceID int) (occurrenceRow, error) {
+	var o occurrenceRow
+	var doors sql.NullTime
+	err := tx.QueryRow(`
+		SELECT id, original_starts_at, starts_at, ends_at, doors_open_at, status, tickets_sold
+		FROM event_occurrences WHERE id = $1 AND event_id = $2 FOR UPDATE`,
+		occurrenceID, eventID,
+	).Scan(&o.ID, &o.OriginalStartsAt, &o.StartsAt, &o.EndsAt, &doors, &o.Status, &o.TicketsSold)
+	if doors.Valid {
+		o.DoorsOpenAt = &doors.Time
+	}
+	return o, err
+}
+
+func UpdateOccurrenceHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateOccurrenceRequest
+	if err := bindInput(c, &req); err != nil || req.EventID == 0 || req.OccurrenceID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "event_id and occurrence_id are required"})
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = ScopeThis
+	}
+	if req.Scope != ScopeThis && req.Scope != ScopeFollowing {
+		var errs fieldErrors
+		errs.add("scope", "invalid_scope", "Scope must be this or following")
+		respondFieldErrors(c, http.StatusBadRequest, errs)
+		return
+	}
+	if !requireEventPermission(c, userID, req.EventID, auth.PermManageEvents) {
+		return
+	}
+
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	if err := tx.QueryRow(`SELECT status FROM events WHERE id = $1 FOR UPDATE`, req.EventID).Scan(&status); err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	if st := events.Status(status); st == events.Cancelled || st == events.Completed {
+		c.JSON(http.StatusConflict, gin.H{"message": fmt.Sprintf("A %s event cannot be edited", st)})
+		return
+	}
+
+	occ, err := loadOccurrence(tx, req.EventID, req.OccurrenceID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Occurrence not found"})
+		return
+	} else if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	if occ.Status == "cancelled" {
+		c.JSON(http.StatusConflict, gin.H{"message": "This occurrence is cancelled"})
+		return
+	}
+	if !occ.StartsAt.After(time.Now()) {
+		c.JSON(http.StatusConflict, gin.H{"message": "Past occurrences cannot be edited"})
+		return
+	}
+
+	series, recurring, err := events.LoadSeries(tx, req.EventID)
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	var errs fieldErrors
+	current := events.Schedule{StartsAt: occ.StartsAt, EndsAt: occ.EndsAt, DoorsOpenAt: occ.DoorsOpenAt, Location: series.Location}
+	next := current
+	if req.StartsAt != "" || req.EndsAt != "" || req.DoorsOpenAt != "" {
+		in := EventScheduleInput{StartsAt: req.StartsAt, EndsAt: req.EndsAt, DoorsOpenAt: req.DoorsOpenAt}
+		next = checkEventSchedule(&errs, in, "", &current)
+	}
+	if req.Capacity != nil && *req.Capacity < occ.TicketsSold {
+		errs.add("capacity", "below_sold", fmt.Sprintf("Capacity cannot be below the %d tickets already sold", occ.TicketsSold))
+	}
+	var newRule *recurrence.Rule
+	if req.RRule != "" {
+		if req.Scope != ScopeFollowing {
+			errs.add("rrule", "requires_following", "A new rule can only be given with scope following")
+		} else {
+			newRule, _ = checkEventRecurrence(&errs, EventRecurrenceInput{RRule: req.RRule}, series.Location)
+		}
+	}
+	if len(errs) > 0 {
+		respondFieldErrors(c, http.StatusBadRequest, errs)
+		return
+	}
+
+	resultID := req.EventID
+	message := "Occurrence updated"
+	switch {
+	case req.Scope == ScopeThis && req.Cancel:
+		_, err = tx.Exec(`UPDATE event_occurrences SET status = 'cancelled', modified = true WHERE id = $1`, occ.ID)
+		message = "Occurrence cancelled"
+	case req.Scope == ScopeThis:
+		_, err = tx.Exec(`
+			UPDATE event_occurrences
+			SET starts_at = $2, ends_at = $3, doors_open_at = $4, capacity = COALESCE($5, capacity), modified = true
+			WHERE id = $1`,
+			occ.ID, next.StartsAt, next.EndsAt, next.DoorsOpenAt, req.Capacity)
+	case !recurring:
+		c.JSON(http.StatusConflict, gin.H{"message": "This event no longer repeats; edit the occurrence alone"})
+		return
+	case occ.OriginalStartsAt.Equal(series.StartsAt):
+		// "This and following" from the first instance is the whole series.
+		if req.Cancel {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Use /cancel-event to cancel the whole series"})
+			return
+		}
+		err = updateWholeSeries(tx, req.EventID, next, newRule, req.Capacity)
+		message = "Series updated"
+	case req.Cancel:
+		err = endSeriesBefore(tx, req.EventID, series, occ.OriginalStartsAt, true)
+		message = "Occurrences cancelled"
+	default:
+		resultID, err = splitSeries(tx, req.EventID, series, occ, next, newRule, req.Capacity)
+		message = "Series split"
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fmt.Println("❌ SQL Update Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not update occurrence"})
+		return
+	}
+
+	if req.Cancel {
+		when := occ.StartsAt.In(series.Location).Format("Mon 2 Jan 2006 15:04")
+		what := "on " + when + " has been cancelled"
+		if req.Scope == ScopeFollowing {
+			what = "from " + when + " onwards has been cancelled"
+		}
+		go notifyEventAudience(req.EventID, what, "")
+	}
+
+	fmt.Printf("✏️ Occurrence %d of Event %d updated (%s) by User %d\n", occ.ID, req.EventID, req.Scope, userID)
+	c.JSON(http.StatusOK, EventResponse{ID: resultID, Message: message, Schedule: renderSchedule(next)})
+}
+
+// updateWholeSeries moves the series' first instance, and with it every
+// unmodified occurrence, to next.
+func updateWholeSeries(tx *sql.Tx, eventID int, next events.Schedule, rule *recurrence.Rule, capacity *int) error {
+	_, err := tx.Exec(`
+		UPDATE events
+		SET starts_at = $2, ends_at = $3, doors_open_at = $4, event_date = $5,
+		    rrule = COALESCE($6, rrule), occurrence_capacity = COALESCE($7, occurrence_capacity)
+		WHERE id = $1`,
+		eventID, next.StartsAt, next.EndsAt, next.DoorsOpenAt, next.LocalDate(), ruleString(rule), capacity)
+	if err != nil {
+		return err
+	}
+	return events.SyncOccurrences(tx, eventID)
+}
+
+// endSeriesBefore stops eventID's rule just before split. Occurrences from
+// split on that sold tickets or were edited are kept, cancelled when cancel
+// is set; the rest are removed.
+func endSeriesBefore(tx *sql.Tx, eventID int, series events.Series, split time.Time, cancel bool) error {
+	ended := *series.Rule
+	ended.Count = 0
+	ended.Until = split.Add(-time.Second)
+
+	var kept []time.Time
+	for _, ex := range series.ExDates {
+		if ex.Before(split) {
+			kept = append(kept, ex)
+		}
+	}
+	_, err := tx.Exec(`UPDATE events SET rrule = $2, exdates = $3 WHERE id = $1`,
+		eventID, ended.String(), events.TimeArray(kept))
+	if err == nil && cancel {
+		_, err = tx.Exec(`
+			UPDATE event_occurrences SET status = 'cancelled', modified = true
+			WHERE event_id = $1 AND original_starts_at >= $2 AND (tickets_sold > 0 OR modified)`,
+			eventID, split)
+	}
+	if err != nil {
+		return err
+	}
+	return events.SyncOccurrences(tx, eventID)
+}
+
+// splitSeries ends eventID's series before occ and starts a new event for
+// occ and everything after it, at next's times and with rule (or the old
+// rule, with COUNT reduced by the instances already past). Occurrences that
+// sold tickets or were edited move to the new event so their sales follow.
+// It returns the new event's ID.
+func splitSeries(tx *sql.Tx, eventID int, series events.Series, occ occurrenceRow, next events.Schedule, rule *recurrence.Rule, capacity *int) (int, error) {
+	split := occ.OriginalStartsAt
+	delta := next.StartsAt.Sub(occ.StartsAt)
+
+	if rule == nil {
+		copied := *series.Rule
+		if copied.Count > 0 {
+			// The instances before occ number fewer than COUNT, so
+			// COUNT is a safe limit however long the series has run.
+			before := len(series.Rule.Expand(series.StartsAt.In(series.Location), split.Add(-time.Second), copied.Count))
+			copied.Count -= before
+		}
+		rule = &copied
+	}
+	var exdates []time.Time
+	for _, ex := range series.ExDates {
+		if !ex.Before(split) {
+			exdates = append(exdates, ex.Add(delta))
+		}
+	}
+
+	var newID int
+	err := tx.QueryRow(`
+		INSERT INTO events
+		(title, description, price, location_lat, location_lng, venue_name, address, category_id, user_id,
+		 featured_image, organization_id, status, published_at, time_zone, occurrence_capacity,
+		 event_date, starts_at, ends_at, doors_open_at, rrule, exdates, recurrence_parent_id)
+		SELECT title, description, price, location_lat, location_lng, venue_name, address, category_id, user_id,
+		       featured_image, organization_id, status, published_at, time_zone, COALESCE($2, occurrence_capacity),
+		       $3, $4, $5, $6, $7, $8, id
+		FROM events WHERE id = $1
+		RETURNING id`,
+		eventID, capacity, next.LocalDate(), next.StartsAt, next.EndsAt, next.DoorsOpenAt,
+		rule.String(), events.TimeArray(exdates),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	secs := delta.Seconds()
+	for _, q := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO event_tags (event_id, name) SELECT $2, name FROM event_tags WHERE event_id = $1`,
+			[]interface{}{eventID, newID}},
+		{`INSERT INTO event_images (event_id, image_url) SELECT $2, image_url FROM event_images WHERE event_id = $1 ORDER BY id`,
+			[]interface{}{eventID, newID}},
+		{`INSERT INTO ticket_types
+		  (event_id, name, description, price, currency, quantity, min_per_order, max_per_order, sales_start_at, sales_end_at, sort_order)
+		  SELECT $2, name, description, price, currency, quantity, min_per_order, max_per_order, sales_start_at, sales_end_at, sort_order
+		  FROM ticket_types WHERE event_id = $1`,
+			[]interface{}{eventID, newID}},
+		// Sold, unedited occurrences shift with the series.
+		{`UPDATE event_occurrences
+		  SET event_id = $2,
+		      original_starts_at = original_starts_at + make_interval(secs => $4),
+		      starts_at = starts_at + make_interval(secs => $4),
+		      ends_at = ends_at + make_interval(secs => $4),
+		      doors_open_at = doors_open_at + make_interval(secs => $4)
+		  WHERE event_id = $1 AND original_starts_at >= $3 AND tickets_sold > 0 AND NOT modified`,
+			[]interface{}{eventID, newID, split, secs}},
+		// Edited occurrences keep their own times.
+		{`UPDATE event_occurrences SET event_id = $2
+		  WHERE event_id = $1 AND original_starts_at >= $3 AND modified`,
+			[]interface{}{eventID, newID, split}},
+		// The moved occurrences' buyers now hold tickets for the new event.
+		{`UPDATE ticket_sales SET event_id = $2
+		  WHERE event_id = $1 AND occurrence_id IN (SELECT id FROM event_occurrences WHERE event_id = $2)`,
+			[]interface{}{eventID, newID}},
+		{`UPDATE tickets SET event_id = $2
+		  WHERE event_id = $1 AND occurrence_id IN (SELECT id FROM event_occurrences WHERE event_id = $2)`,
+			[]interface{}{eventID, newID}},
+		// Their seats now count against the new event's copies of the
+		// ticket types, matched by name, and no longer against the old ones.
+		{`UPDATE ticket_sales s SET ticket_type_id = nt.id
+		  FROM ticket_types ot, ticket_types nt
+		  WHERE s.event_id = $2 AND s.ticket_type_id = ot.id AND ot.event_id = $1
+		    AND nt.event_id = $2 AND lower(nt.name) = lower(ot.name)`,
+			[]interface{}{eventID, newID}},
+		{`UPDATE tickets tk SET ticket_type_id = nt.id
+		  FROM ticket_types ot, ticket_types nt
+		  WHERE tk.event_id = $2 AND tk.ticket_type_id = ot.id AND ot.event_id = $1
+		    AND nt.event_id = $2 AND lower(nt.name) = lower(ot.name)`,
+			[]interface{}{eventID, newID}},
+		{`UPDATE ticket_types t SET sold = m.n
+		  FROM (SELECT ticket_type_id, SUM(quantity) AS n FROM ticket_sales
+		        WHERE event_id = $1 AND status IN ('pending', 'completed')
+		        GROUP BY ticket_type_id) m
+		  WHERE t.id = m.ticket_type_id AND t.event_id = $1`,
+			[]interface{}{newID}},
+		{`UPDATE ticket_types ot SET sold = GREATEST(ot.sold - nt.sold, 0)
+		  FROM ticket_types nt
+		  WHERE ot.event_id = $1 AND nt.event_id = $2 AND lower(nt.name) = lower(ot.name)`,
+			[]interface{}{eventID, newID}},
+	} {
+		if _, err := tx.Exec(q.query, q.args...); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := endSeriesBefore(tx, eventID, series, split, false); err != nil {
+		return 0, err
+	}
+	return newID, events.SyncOccurrences(tx, newID)
+}
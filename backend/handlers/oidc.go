@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"local-event-backend/auth"
+)
+
+const oidcCookie = "oidc_login"
+
+// OIDCStartHandler redirects the browser to the provider named in
+// ?provider=, remembering state, nonce and the PKCE verifier in a short-lived
+// signed cookie.
+func OIDCStartHandler(c *gin.Context) {
+	provider, ok := auth.OIDCProviderByName(c.Query("provider"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Unknown login provider"})
+		return
+	}
+
+	login, cookie, err := auth.NewOIDCLogin(provider.Name)
+	if err != nil {
+		fmt.Println("❌ OIDC State Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), login.State, login.Nonce, login.Verifier)
+	if err != nil {
+		fmt.Println("❌ OIDC Discovery Error:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"message": "Login provider is unavailable"})
+		return
+	}
+
+	// Lax so the cookie comes back on the provider's top-level redirect.
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcCookie, cookie, 600, "/auth/oidc", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallbackHandler finishes the login: it checks state, redeems the code
+// with the PKCE verifier, verifies the ID token and nonce, links or creates
+// the user and hands the frontend the usual access and refresh tokens.
+func OIDCCallbackHandler(c *gin.Context) {
+	cookie, _ := c.Cookie(oidcCookie)
+	c.SetCookie(oidcCookie, "", -1, "/auth/oidc", "", c.Request.TLS != nil, true)
+
+	if e := c.Query("error"); e != "" {
+		oidcFailed(c, e)
+		return
+	}
+
+	login, err := auth.ParseOIDCLogin(cookie)
+	if err != nil {
+		fmt.Println("❌ OIDC State Error:", err)
+		oidcFailed(c, "invalid_state")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Query("state")), []byte(login.State)) != 1 {
+		oidcFailed(c, "invalid_state")
+		return
+	}
+	provider, ok := auth.OIDCProviderByName(login.Provider)
+	if !ok {
+		oidcFailed(c, "invalid_state")
+		return
+	}
+
+	ctx := c.Request.Context()
+	rawIDToken, err := provider.Exchange(ctx, c.Query("code"), login.Verifier)
+	if err != nil {
+		fmt.Println("❌ OIDC Exchange Error:", err)
+		oidcFailed(c, "exchange_failed")
+		return
+	}
+	identity, err := provider.VerifyIDToken(ctx, rawIDToken, login.Nonce)
+	if err != nil {
+		fmt.Println("❌ OIDC ID Token Error:", err)
+		oidcFailed(c, "invalid_id_token")
+		return
+	}
+
+	userID, err := auth.LinkOIDCIdentity(provider.Name, identity)
+	if errors.Is(err, auth.ErrOIDCNoEmail) {
+		oidcFailed(c, "email_required")
+		return
+	} else if errors.Is(err, auth.ErrOIDCEmailUnverified) {
+		oidcFailed(c, "email_unverified")
+		return
+	} else if err != nil {
+		fmt.Println("❌ OIDC Link Error:", err)
+		oidcFailed(c, "server_error")
+		return
+	}
+
+	// Tokens go in the fragment so they never reach server logs.
+	fragment := url.Values{}
+	fragment.Set("user_id", strconv.Itoa(userID))
+
+	mfaEnabled, err := auth.MFAEnabled(userID)
+	if err != nil {
+		fmt.Println("❌ OIDC MFA Error:", err)
+		oidcFailed(c, "server_error")
+		return
+	}
+	if mfaEnabled {
+		mfaToken, err := auth.NewMFAPendingToken(userID, false)
+		if err != nil {
+			fmt.Println("❌ Token Generation Failed:", err)
+			oidcFailed(c, "server_error")
+			return
+		}
+		fragment.Set("mfa_token", mfaToken)
+		c.Redirect(http.StatusFound, appURL()+"/auth/callback#"+fragment.Encode())
+		return
+	}
+
+	tokens, err := auth.StartSession(userID, false, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		fmt.Println("❌ Token Generation Failed:", err)
+		oidcFailed(c, "server_error")
+		return
+	}
+
+	fmt.Printf("✅ User %d logged in with %s\n", userID, provider.Name)
+	fragment.Set("token", tokens.AccessToken)
+	fragment.Set("refresh_token", tokens.RefreshToken)
+	fragment.Set("expires_in", strconv.Itoa(tokens.ExpiresIn))
+	c.Redirect(http.StatusFound, appURL()+"/auth/callback#"+fragment.Encode())
+}
+
+func oidcFailed(c *gin.Context, reason string) {
+	c.Redirect(http.StatusFound, appURL()+"/auth/login?error="+url.QueryEscape(reason))
+}
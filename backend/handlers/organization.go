@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"local-event-backend/auth"
+	"local-event-backend/utils"
+	"local-event-backend/validate"
+)
+
+type CreateOrganizationRequest struct {
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	Description string `json:"description"`
+	LogoURL     string `json:"logo_url"`
+	Website     string `json:"website"`
+}
+
+type OrganizationResponse struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	Description string `json:"description"`
+	LogoURL     string `json:"logo_url"`
+	Website     string `json:"website"`
+	Role        string `json:"role,omitempty"`
+}
+
+// OrganizationMemberRequest names the member by email when adding them and
+// by user_id when changing or removing them.
+type OrganizationMemberRequest struct {
+	OrganizationID int    `json:"organization_id"`
+	Email          string `json:"email"`
+	UserID         int    `json:"user_id"`
+	Role           string `json:"role"`
+}
+
+var slugUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+func slugify(s string) string {
+	return strings.Trim(slugUnsafe.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// requireOrgPermission answers 403 and returns false unless the caller holds
+// perm in orgID.
+func requireOrgPermission(c *gin.Context, userID, orgID int, perm auth.Permission) bool {
+	allowed, err := auth.CanInOrg(userID, orgID, perm)
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"message": "You do not have permission to do this for this organization"})
+		return false
+	}
+	return true
+}
+
+// CreateOrganizationHandler creates an organization with the caller as its
+// owner.
+func CreateOrganizationHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req CreateOrganizationRequest
+	if err := bindInput(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+
+	var errs fieldErrors
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		errs.add("name", "required", "Organization name is required")
+	}
+	slug := slugify(req.Slug)
+	if slug == "" {
+		slug = slugify(name)
+	}
+	if slug == "" && name != "" {
+		errs.add("slug", "invalid_slug", "Use letters or digits in the organization's short name")
+	}
+	if len(errs) > 0 {
+		respondFieldErrors(c, http.StatusBadRequest, errs)
+		return
+	}
+
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	org := OrganizationResponse{
+		Name:        name,
+		Slug:        slug,
+		Description: strings.TrimSpace(req.Description),
+		LogoURL:     strings.TrimSpace(req.LogoURL),
+		Website:     strings.TrimSpace(req.Website),
+		Role:        auth.OrgRoleOwner,
+	}
+	err = tx.QueryRow(`
+		INSERT INTO organizations (name, slug, description, logo_url, website, created_by)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), $6)
+		RETURNING id`,
+		org.Name, org.Slug, org.Description, org.LogoURL, org.Website, userID,
+	).Scan(&org.ID)
+	if isUniqueViolation(err) {
+		var taken fieldErrors
+		taken.add("slug", "slug_taken", "An organization with this short name already exists")
+		respondFieldErrors(c, http.StatusConflict, taken)
+		return
+	} else if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO organization_members (organization_id, user_id, role) VALUES ($1, $2, $3)`,
+		org.ID, userID, auth.OrgRoleOwner,
+	)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	fmt.Printf("✅ Organization %d created by User %d\n", org.ID, userID)
+	c.JSON(http.StatusOK, org)
+}
+
+// MyOrganizationsHandler lists the organizations the caller belongs to.
+func MyOrganizationsHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	rows, err := utils.DB.Query(`
+		SELECT o.id, o.name, o.slug, COALESCE(o.description, ''), COALESCE(o.logo_url, ''), COALESCE(o.website, ''), m.role
+		FROM organizations o
+		JOIN organization_members m ON m.organization_id = o.id
+		WHERE m.user_id = $1
+		ORDER BY o.name`, userID)
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	defer rows.Close()
+
+	orgs := []OrganizationResponse{}
+	for rows.Next() {
+		var o OrganizationResponse
+		if err := rows.Scan(&o.ID, &o.Name, &o.Slug, &o.Description, &o.LogoURL, &o.Website, &o.Role); err != nil {
+			fmt.Println("❌ DB ERROR:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+			return
+		}
+		orgs = append(orgs, o)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"organizations": orgs})
+}
+
+// AddOrganizationMemberHandler lets an owner add an existing user. Owners and
+// managers can use the event actions as organizer for as long as they stay
+// members; see auth.UserRoles.
+func AddOrganizationMemberHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req OrganizationMemberRequest
+	if err := bindInput(c, &req); err != nil || req.OrganizationID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "organization_id, email and role are required"})
+		return
+	}
+	var errs fieldErrors
+	email, err := validate.Email(req.Email)
+	errs.check("email", err)
+	if !auth.ValidOrgRole(req.Role) {
+		errs.add("role", "invalid_role", "Role must be owner, manager or door-staff")
+	}
+	if len(errs) > 0 {
+		respondFieldErrors(c, http.StatusBadRequest, errs)
+		return
+	}
+
+	if !requireOrgPermission(c, userID, req.OrganizationID, auth.PermManageMembers) {
+		return
+	}
+
+	var memberID int
+	err = utils.DB.QueryRow(`SELECT id FROM users WHERE lower(email) = $1 AND deleted_at IS NULL`, email).Scan(&memberID)
+	if err == sql.ErrNoRows {
+		var missing fieldErrors
+		missing.add("email", "user_not_found", "No Minab account uses this email")
+		respondFieldErrors(c, http.StatusNotFound, missing)
+		return
+	} else if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT INTO organization_members (organization_id, user_id, role) VALUES ($1, $2, $3)`,
+		req.OrganizationID, memberID, req.Role,
+	)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"message": "This user is already a member"})
+		return
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member added", "user_id": memberID})
+}
+
+// UpdateOrganizationMemberHandler changes a member's role. The last owner
+// cannot be demoted.
+func UpdateOrganizationMemberHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req OrganizationMemberRequest
+	if err := bindInput(c, &req); err != nil || req.OrganizationID == 0 || req.UserID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "organization_id, user_id and role are required"})
+		return
+	}
+	if !auth.ValidOrgRole(req.Role) {
+		var errs fieldErrors
+		errs.add("role", "invalid_role", "Role must be owner, manager or door-staff")
+		respondFieldErrors(c, http.StatusBadRequest, errs)
+		return
+	}
+
+	if !requireOrgPermission(c, userID, req.OrganizationID, auth.PermManageMembers) {
+		return
+	}
+
+	changeMembership(c, req.OrganizationID, req.UserID, func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			`UPDATE organization_members SET role = $3 WHERE organization_id = $1 AND user_id = $2`,
+			req.OrganizationID, req.UserID, req.Role,
+		)
+		return err
+	}, "Member updated")
+}
+
+// RemoveOrganizationMemberHandler lets an owner remove a member, or any
+// member leave. The last owner cannot leave.
+func RemoveOrganizationMemberHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req OrganizationMemberRequest
+	if err := bindInput(c, &req); err != nil || req.OrganizationID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "organization_id and user_id are required"})
+		return
+	}
+	if req.UserID == 0 {
+		req.UserID = userID
+	}
+
+	if req.UserID != userID && !requireOrgPermission(c, userID, req.OrganizationID, auth.PermManageMembers) {
+		return
+	}
+
+	changeMembership(c, req.OrganizationID, req.UserID, func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			`DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2`,
+			req.OrganizationID, req.UserID,
+		)
+		return err
+	}, "Member removed")
+}
+
+// changeMembership runs change against memberID's row and commits it only if
+// the organization still has an owner afterwards. The organization row is
+// locked so two concurrent changes cannot remove both remaining owners.
+func changeMembership(c *gin.Context, orgID, memberID int, change func(*sql.Tx) error, done string) {
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT 1 FROM organizations WHERE id = $1 FOR UPDATE`, orgID); err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	var exists bool
+	err = tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM organization_members WHERE organization_id = $1 AND user_id = $2)`,
+		orgID, memberID,
+	).Scan(&exists)
+	if err == nil && !exists {
+		c.JSON(http.StatusNotFound, gin.H{"message": "This user is not a member"})
+		return
+	}
+	if err == nil {
+		err = change(tx)
+	}
+
+	var owners int
+	if err == nil {
+		err = tx.QueryRow(
+			`SELECT COUNT(*) FROM organization_members WHERE organization_id = $1 AND role = $2`,
+			orgID, auth.OrgRoleOwner,
+		).Scan(&owners)
+	}
+	if err == nil && owners == 0 {
+		c.JSON(http.StatusConflict, gin.H{"message": "An organization must keep at least one owner"})
+		return
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": done})
+}
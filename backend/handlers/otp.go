@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"local-event-backend/auth"
+	"local-event-backend/sms"
+	"local-event-backend/utils"
+	"local-event-backend/validate"
+)
+
+type RequestOTPRequest struct {
+	PhoneNumber string `json:"phone_number"`
+}
+
+type VerifyOTPRequest struct {
+	PhoneNumber string `json:"phone_number"`
+	Code        string `json:"code"`
+	RememberMe  bool   `json:"remember_me"`
+}
+
+// RequestOTPHandler texts a login code to a registered number. It answers
+// the same way for unknown numbers so it cannot be used to find accounts.
+func RequestOTPHandler(c *gin.Context) {
+	var req RequestOTPRequest
+	if err := bindInput(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+
+	var errs fieldErrors
+	phone, err := validate.Phone(req.PhoneNumber)
+	errs.check("phone_number", err)
+	if err == nil && phone == "" {
+		errs.add("phone_number", "required", "Phone number is required")
+	}
+	if len(errs) > 0 {
+		respondFieldErrors(c, http.StatusBadRequest, errs)
+		return
+	}
+	response := gin.H{"message": "If this number is registered, a code has been sent"}
+
+	var userID int
+	err = utils.DB.QueryRow(`SELECT id FROM users WHERE phone_number = $1`, phone).Scan(&userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, response)
+		return
+	} else if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	// Throttling and delivery failures are only logged: a different answer
+	// here would tell the caller that the number has an account.
+	code, err := auth.IssuePhoneOTP(phone)
+	if errors.Is(err, auth.ErrOTPRateLimited) {
+		fmt.Println("⚠️ OTP rate limited for a registered number")
+		c.JSON(http.StatusOK, response)
+		return
+	} else if err != nil {
+		fmt.Println("❌ OTP Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	if err := sms.Default.SendSMS(phone, fmt.Sprintf("Your Minab login code is %s. It expires in 5 minutes.", code)); err != nil {
+		fmt.Println("❌ SMS Error:", err)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func VerifyOTPHandler(c *gin.Context) {
+	var req VerifyOTPRequest
+	if err := bindInput(c, &req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, LoginResponse{Message: "phone_number and code are required"})
+		return
+	}
+	phone, err := validate.Phone(req.PhoneNumber)
+	if err != nil || phone == "" {
+		c.JSON(http.StatusBadRequest, LoginResponse{Message: "phone_number and code are required"})
+		return
+	}
+
+	err = auth.VerifyPhoneOTP(phone, req.Code)
+	if errors.Is(err, auth.ErrInvalidOTP) {
+		c.JSON(http.StatusUnauthorized, LoginResponse{Message: "Invalid or expired code"})
+		return
+	} else if err != nil {
+		fmt.Println("❌ OTP Error:", err)
+		c.JSON(http.StatusInternalServerError, LoginResponse{Message: "Internal server error"})
+		return
+	}
+
+	var userID int
+	var email string
+	err = utils.DB.QueryRow(`SELECT id, email FROM users WHERE phone_number = $1`, phone).Scan(&userID, &email)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusUnauthorized, LoginResponse{Message: "Invalid or expired code"})
+		return
+	} else if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, LoginResponse{Message: "Internal server error"})
+		return
+	}
+
+	finishPrimaryLogin(c, email, userID, req.RememberMe)
+}
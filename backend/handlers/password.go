@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"local-event-backend/auth"
+	"local-event-backend/mailer"
+	"local-event-backend/utils"
+	"local-event-backend/validate"
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ForgotPasswordHandler always answers the same way so it cannot be used to
+// find out which emails have accounts.
+func ForgotPasswordHandler(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := bindInput(c, &req); err != nil || strings.TrimSpace(req.Email) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "email is required"})
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	response := gin.H{"message": "If an account exists for this email, a reset link has been sent"}
+
+	var userID int
+	err := utils.DB.QueryRow(`SELECT id FROM users WHERE lower(email) = $1`, email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, response)
+		return
+	} else if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	token, err := auth.NewPasswordResetToken(userID)
+	if err != nil {
+		fmt.Println("❌ Reset Token Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	link := fmt.Sprintf("%s/auth/reset-password?token=%s", appURL(), url.QueryEscape(token))
+	err = mailer.Default.Send(mailer.Message{
+		To:      email,
+		Subject: "Reset your Minab password",
+		Body:    "Someone asked to reset the password for your Minab account. If it was you, open the link below within an hour. Otherwise you can ignore this email.\n\n" + link,
+	})
+	if err != nil {
+		fmt.Println("❌ Mail Error:", err)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func ResetPasswordHandler(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := bindInput(c, &req); err != nil || req.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "token and new_password are required"})
+		return
+	}
+	var errs fieldErrors
+	errs.check("new_password", validate.Password(req.NewPassword, ""))
+	if len(errs) > 0 {
+		respondFieldErrors(c, http.StatusBadRequest, errs)
+		return
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Encryption failed"})
+		return
+	}
+
+	userID, err := auth.ResetPassword(req.Token, string(hashed))
+	if errors.Is(err, auth.ErrInvalidResetToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "This reset link is invalid or has expired"})
+		return
+	} else if err != nil {
+		fmt.Println("❌ Reset Password Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	if err := auth.LogoutAll(userID); err != nil {
+		fmt.Println("❌ Logout-all Error:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset, please log in"})
+}
+
+func ChangePasswordHandler(c *gin.Context) {
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := bindInput(c, &req); err != nil || req.CurrentPassword == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "current_password and new_password are required"})
+		return
+	}
+	var errs fieldErrors
+	errs.check("new_password", validate.Password(req.NewPassword, ""))
+	if len(errs) > 0 {
+		respondFieldErrors(c, http.StatusBadRequest, errs)
+		return
+	}
+
+	var email, storedHash string
+	err := utils.DB.QueryRow(`SELECT email, password FROM users WHERE id = $1`, principal.UserID).Scan(&email, &storedHash)
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	if !reservePasswordAttempt(c, email) {
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(req.CurrentPassword)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Current password is incorrect"})
+		return
+	}
+	if err := auth.Logins.Succeeded(email, c.ClientIP()); err != nil {
+		fmt.Println("❌ Login Throttle Error:", err)
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Encryption failed"})
+		return
+	}
+	if _, err := utils.DB.Exec(`UPDATE users SET password = $1, has_password = true WHERE id = $2`, string(hashed), principal.UserID); err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	// A session that cannot be named (an action request carrying a token
+	// from before x-hasura-session-id) is signed out along with the rest.
+	if err := auth.LogoutOthers(principal.UserID, principal.SessionID); err != nil {
+		fmt.Println("❌ Logout Error:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
+
+// reservePasswordAttempt counts a guess at email's password against the
+// same throttle as /login, so signed-in endpoints that ask for the password
+// cannot be used to brute-force it. It answers the request and returns false
+// when the caller has to wait.
+func reservePasswordAttempt(c *gin.Context, email string) bool {
+	wait, err := auth.Logins.Attempt(email, c.ClientIP())
+	if err != nil {
+		fmt.Println("❌ Login Throttle Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return false
+	}
+	if wait > 0 {
+		respondThrottled(c, wait)
+		return false
+	}
+	return true
+}
+
+// throttledError is returned when the login throttle asks the caller to
+// wait before guessing again.
+type throttledError struct {
+	wait time.Duration
+}
+
+func (e throttledError) Error() string {
+	return fmt.Sprintf("throttled for %s", e.wait)
+}
+
+func respondThrottled(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"message": fmt.Sprintf("Too many failed attempts. Try again in %s.", wait.Round(time.Second)),
+	})
+}
@@ -1,71 +1,64 @@
 package handlers
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"net/http"
-	"os"
 	"strings"
-	"time"
+
 	"github.com/gin-gonic/gin"
 )
 
 type HasuraActionPayload struct {
 	Input struct {
-		EventID  int    `json:"event_id"`
-		FullName string `json:"full_name"`
-		Email    string `json:"email"`
+		EventID      int    `json:"event_id"`
+		TicketTypeID int    `json:"ticket_type_id"`
+		Quantity     int    `json:"quantity"`
+		OccurrenceID *int   `json:"occurrence_id"`
+		FullName     string `json:"full_name"`
+		Email        string `json:"email"`
 	} `json:"input"`
 }
 
+// InitializePaymentHandler is the checkout used by the event page. It takes
+// the same path as /purchase-ticket, so the order is reserved and priced on
+// the server, and answers in the shape the page expects.
 func InitializePaymentHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
 	var payload HasuraActionPayload
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		c.JSON(400, gin.H{"checkout_url": "", "status": "failed", "message": "Invalid input"})
 		return
 	}
 
-	// --- THE ULTIMATE EMAIL FIX ---
-	email := strings.TrimSpace(payload.Input.Email)
-	if email == "" || !strings.Contains(email, "@") {
-		email = "customer.success@gmail.com" // Force valid email for Chapa
+	if payload.Input.Quantity <= 0 {
+		payload.Input.Quantity = 1
 	}
-
-	apiKey := os.Getenv("CHAPA_SECRET_KEY")
-	txRef := fmt.Sprintf("tx-minab-1-%d-%d", payload.Input.EventID, time.Now().UnixNano())
-
-	chapaBody := map[string]interface{}{
-		"amount": "250", "currency": "ETB", "email": email, 
-		"first_name": payload.Input.FullName, "tx_ref": txRef,
-		"callback_url": "https://webhook.site/minab",
-		"return_url":   "http://localhost:3000/events/verify",
-		"customization": map[string]interface{}{ "title": "Minab Pass" },
+	req := PurchaseTicketRequest{
+		EventID:      payload.Input.EventID,
+		TicketTypeID: payload.Input.TicketTypeID,
+		Quantity:     payload.Input.Quantity,
+		OccurrenceID: payload.Input.OccurrenceID,
 	}
 
-	jsonData, _ := json.Marshal(chapaBody)
-	req, _ := http.NewRequest("POST", "https://api.chapa.co/v1/transaction/initialize", bytes.NewBuffer(jsonData))
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
+	// Chapa rejects malformed addresses; fall back to the account's own.
+	customer := loadCustomer(userID)
+	if email := strings.TrimSpace(payload.Input.Email); email != "" && strings.Contains(email, "@") {
+		customer.Email = email
+	}
+	if parts := strings.Fields(payload.Input.FullName); len(parts) > 0 {
+		customer.FirstName = parts[0]
+		customer.LastName = strings.Join(parts[1:], " ")
+	}
 
-	resp, err := (&http.Client{}).Do(req)
-	if err != nil {
-		c.JSON(500, gin.H{"message": "Chapa connection failed"})
+	response, ok := checkout(c, userID, req, customer, "/events/verify")
+	if !ok {
 		return
 	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-
-	// Force status/message to be Strings
-	statusStr := fmt.Sprintf("%v", result["status"])
-	msgStr := fmt.Sprintf("%v", result["message"])
-
-	if statusStr == "success" {
-		data := result["data"].(map[string]interface{})
-		c.JSON(200, gin.H{"checkout_url": fmt.Sprintf("%v", data["checkout_url"]), "status": "success", "message": "Success"})
-	} else {
-		c.JSON(400, gin.H{"checkout_url": "", "status": "failed", "message": msgStr})
+	message := "Success"
+	if response.CheckoutURL == "" {
+		message = "Tickets issued"
 	}
-}
\ No newline at end of file
+	c.JSON(200, gin.H{"checkout_url": response.CheckoutURL, "status": "success", "message": message})
+}
@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"local-event-backend/auth"
+	"local-event-backend/mailer"
+	"local-event-backend/utils"
+	"local-event-backend/validate"
+)
+
+// NotificationPreferences is stored as JSON in users.notification_preferences.
+type NotificationPreferences struct {
+	Email        bool `json:"email"`
+	SMS          bool `json:"sms"`
+	EventUpdates bool `json:"event_updates"`
+	Marketing    bool `json:"marketing"`
+}
+
+type ProfileResponse struct {
+	ID                      int                     `json:"id"`
+	Name                    string                  `json:"name"`
+	Email                   string                  `json:"email"`
+	EmailVerified           bool                    `json:"email_verified"`
+	PendingEmail            string                  `json:"pending_email,omitempty"`
+	PhoneNumber             string                  `json:"phone_number"`
+	AvatarURL               string                  `json:"avatar_url"`
+	PreferredLanguage       string                  `json:"preferred_language"`
+	NotificationPreferences NotificationPreferences `json:"notification_preferences"`
+	Roles                   []string                `json:"roles"`
+	CreatedAt               time.Time               `json:"created_at"`
+}
+
+// UpdateProfileRequest changes only the fields that are present. The avatar
+// is sent like an /upload request: base64 data plus the original file name.
+type UpdateProfileRequest struct {
+	Name                    *string                  `json:"name"`
+	PhoneNumber             *string                  `json:"phone_number"`
+	Email                   *string                  `json:"email"`
+	CurrentPassword         string                   `json:"current_password"`
+	Code                    string                   `json:"code"`
+	AvatarBase64            string                   `json:"avatar_base64"`
+	AvatarName              string                   `json:"avatar_name"`
+	RemoveAvatar            bool                     `json:"remove_avatar"`
+	PreferredLanguage       *string                  `json:"preferred_language"`
+	NotificationPreferences *NotificationPreferences `json:"notification_preferences"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// reauthWindow is how recent a sign-in counts as re-authentication for
+// accounts without a password.
+const reauthWindow = 10 * time.Minute
+
+func loadProfile(userID int) (ProfileResponse, error) {
+	var p ProfileResponse
+	var name, pendingEmail, phone, avatar sql.NullString
+	var verifiedAt sql.NullTime
+	var prefs []byte
+	err := utils.DB.QueryRow(`
+		SELECT id, name, email, email_verified_at, pending_email, phone_number, avatar_url,
+		       preferred_language, notification_preferences, created_at
+		FROM users WHERE id = $1 AND deleted_at IS NULL`, userID,
+	).Scan(&p.ID, &name, &p.Email, &verifiedAt, &pendingEmail, &phone, &avatar,
+		&p.PreferredLanguage, &prefs, &p.CreatedAt)
+	if err != nil {
+		return p, err
+	}
+	p.Name = name.String
+	p.EmailVerified = verifiedAt.Valid
+	p.PendingEmail = pendingEmail.String
+	p.PhoneNumber = phone.String
+	p.AvatarURL = avatar.String
+	if err := json.Unmarshal(prefs, &p.NotificationPreferences); err != nil {
+		return p, err
+	}
+	p.Roles, err = auth.UserRoles(userID)
+	return p, err
+}
+
+func GetProfileHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	profile, err := loadProfile(userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"message": "User not found"})
+		return
+	} else if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+func UpdateProfileHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := bindInput(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+
+	var currentEmail string
+	err := utils.DB.QueryRow(
+		`SELECT email FROM users WHERE id = $1 AND deleted_at IS NULL`, userID,
+	).Scan(&currentEmail)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"message": "User not found"})
+		return
+	} else if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	var errs fieldErrors
+	var sets []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			errs.add("name", "required", "Name is required")
+		}
+		set("name", name)
+	}
+	if req.PhoneNumber != nil {
+		phone, err := validate.Phone(*req.PhoneNumber)
+		errs.check("phone_number", err)
+		set("phone_number", sql.NullString{String: phone, Valid: phone != ""})
+	}
+	if req.PreferredLanguage != nil {
+		lang, err := validate.Language(*req.PreferredLanguage)
+		errs.check("preferred_language", err)
+		set("preferred_language", lang)
+	}
+	if req.NotificationPreferences != nil {
+		prefs, _ := json.Marshal(req.NotificationPreferences)
+		set("notification_preferences", string(prefs))
+	}
+
+	// A new address only replaces the current one once its owner opens the
+	// verification link, so a typo or stolen session cannot take the account.
+	var newEmail string
+	if req.Email != nil {
+		email, err := validate.Email(*req.Email)
+		errs.check("email", err)
+		if err == nil && email != currentEmail {
+			var throttled throttledError
+			if ok, err := reauthenticate(c, req.CurrentPassword, req.Code); errors.As(err, &throttled) {
+				respondThrottled(c, throttled.wait)
+				return
+			} else if err != nil {
+				fmt.Println("❌ DB ERROR:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+				return
+			} else if !ok {
+				errs.add("current_password", "invalid_password", "Enter your current password, or a verification code if your account has none, to change your email")
+			}
+			var taken bool
+			if err := utils.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE lower(email) = $1)`, email).Scan(&taken); err == nil && taken {
+				errs.add("email", "email_taken", "A user with this email already exists")
+			}
+			newEmail = email
+			set("pending_email", email)
+		}
+	}
+
+	if len(errs) > 0 {
+		respondFieldErrors(c, http.StatusBadRequest, errs)
+		return
+	}
+
+	if req.RemoveAvatar {
+		set("avatar_url", nil)
+	} else if req.AvatarBase64 != "" {
+		avatarURL, err := saveUpload(req.AvatarName, req.AvatarBase64)
+		if err != nil {
+			fmt.Println("❌ Avatar Upload Error:", err)
+			var bad fieldErrors
+			bad.add("avatar_base64", "invalid_upload", err.Error())
+			respondFieldErrors(c, http.StatusBadRequest, bad)
+			return
+		}
+		set("avatar_url", avatarURL)
+	}
+
+	if len(sets) > 0 {
+		args = append(args, userID)
+		query := fmt.Sprintf(`UPDATE users SET %s WHERE id = $%d`, strings.Join(sets, ", "), len(args))
+		if _, err := utils.DB.Exec(query, args...); uniqueConstraint(err) == "users_phone_number_key" {
+			var taken fieldErrors
+			taken.add("phone_number", "phone_taken", "A user with this phone number already exists")
+			respondFieldErrors(c, http.StatusConflict, taken)
+			return
+		} else if err != nil {
+			fmt.Println("❌ DB ERROR:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+			return
+		}
+	}
+
+	if newEmail != "" {
+		if err := sendVerificationEmail(userID, newEmail); err != nil {
+			fmt.Println("⚠️ Verification Mail Warning:", err)
+		}
+		notice := mailer.Message{
+			To:      currentEmail,
+			Subject: "Your Minab email address is changing",
+			Body:    "Someone asked to change the email address on your Minab account to " + newEmail + ". The change only happens once the new address is verified. If this wasn't you, reset your password now.",
+		}
+		if err := mailer.Default.Send(notice); err != nil {
+			fmt.Println("⚠️ Mail Warning:", err)
+		}
+	}
+
+	profile, err := loadProfile(userID)
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// DeleteAccountHandler anonymizes the caller instead of deleting the row:
+// bookmarks, follows and ticket sales keep pointing at it, so event counts
+// and sales history stay intact while nothing identifies the person. The
+// only owner of an organization has to hand it over first.
+func DeleteAccountHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req DeleteAccountRequest
+	if err := bindInput(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+
+	var throttled throttledError
+	ok, err := reauthenticate(c, req.Password, req.Code)
+	if errors.As(err, &throttled) {
+		respondThrottled(c, throttled.wait)
+		return
+	} else if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"message": "User not found"})
+		return
+	} else if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Password is incorrect, or sign in again to confirm"})
+		return
+	}
+
+	var sole soleOwnerError
+	if err := anonymizeUser(userID); errors.As(err, &sole) {
+		c.JSON(http.StatusConflict, gin.H{
+			"message":       "Make someone else an owner of " + strings.Join(sole.orgs, ", ") + " before deleting your account",
+			"organizations": sole.orgs,
+		})
+		return
+	} else if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	if err := auth.LogoutAll(userID); err != nil {
+		fmt.Println("❌ Logout Error:", err)
+	}
+
+	fmt.Printf("✅ Anonymized User %d\n", userID)
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted"})
+}
+
+// reauthenticate checks that the caller has just proved who they are. With a
+// password, that means giving it. Accounts without one (created through a
+// social login) give code instead: a two-factor or backup code, or a code
+// texted to their phone by /request-otp. A sign-in within reauthWindow also
+// counts for them. Passwords and codes are guesses, so they go through the
+// login throttle; a throttledError says how long to wait. It returns
+// sql.ErrNoRows when the account is gone.
+func reauthenticate(c *gin.Context, password, code string) (bool, error) {
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return false, nil
+	}
+
+	var email, storedHash string
+	var hasPassword bool
+	var phone sql.NullString
+	err := utils.DB.QueryRow(
+		`SELECT email, password, has_password, phone_number FROM users WHERE id = $1 AND deleted_at IS NULL`,
+		principal.UserID,
+	).Scan(&email, &storedHash, &hasPassword, &phone)
+	if err != nil {
+		return false, err
+	}
+
+	code = strings.TrimSpace(code)
+	if hasPassword && password == "" {
+		return false, nil
+	}
+	if hasPassword || code != "" {
+		wait, err := auth.Logins.Attempt(email, c.ClientIP())
+		if err != nil {
+			return false, err
+		}
+		if wait > 0 {
+			return false, throttledError{wait}
+		}
+		ok, err := checkSecret(principal.UserID, hasPassword, storedHash, password, code, phone)
+		if ok {
+			if err := auth.Logins.Succeeded(email, c.ClientIP()); err != nil {
+				fmt.Println("❌ Login Throttle Error:", err)
+			}
+		}
+		return ok, err
+	}
+
+	if principal.SessionID == "" {
+		return false, nil
+	}
+	started, err := auth.SessionStartedAt(principal.UserID, principal.SessionID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil && time.Since(started) < reauthWindow, err
+}
+
+// checkSecret checks what reauthenticate was given: the password for
+// accounts that have one, otherwise a two-factor, backup or texted code.
+func checkSecret(userID int, hasPassword bool, storedHash, password, code string, phone sql.NullString) (bool, error) {
+	if hasPassword {
+		return password != "" && bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(password)) == nil, nil
+	}
+	err := auth.VerifyMFA(userID, code)
+	if err == nil {
+		return true, nil
+	} else if !errors.Is(err, auth.ErrInvalidMFACode) && !errors.Is(err, auth.ErrMFANotEnrolled) {
+		return false, err
+	}
+	if phone.Valid {
+		err := auth.VerifyPhoneOTP(phone.String, code)
+		if err == nil {
+			return true, nil
+		} else if !errors.Is(err, auth.ErrInvalidOTP) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// soleOwnerError lists the organizations that would be left without an
+// owner if the user went away.
+type soleOwnerError struct {
+	orgs []string
+}
+
+func (e soleOwnerError) Error() string {
+	return "sole owner of " + strings.Join(e.orgs, ", ")
+}
+
+// anonymizeUser scrubs personal data, every way of signing in and every
+// role or organization membership. The password becomes a value bcrypt can
+// never match. It returns a soleOwnerError, changing nothing, while the user
+// is the only owner of an organization.
+func anonymizeUser(userID int) error {
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the organizations the user owns, as changeMembership does, so a
+	// co-owner cannot step down while this check runs.
+	_, err = tx.Exec(`
+		SELECT 1 FROM organizations WHERE id IN (
+			SELECT organization_id FROM organization_members WHERE user_id = $1 AND role = $2
+		)
+		ORDER BY id FOR UPDATE`, userID, auth.OrgRoleOwner)
+	if err != nil {
+		return err
+	}
+	rows, err := tx.Query(`
+		SELECT o.name FROM organizations o
+		JOIN organization_members m ON m.organization_id = o.id AND m.user_id = $1 AND m.role = $2
+		WHERE NOT EXISTS (
+			SELECT 1 FROM organization_members other
+			WHERE other.organization_id = o.id AND other.role = $2 AND other.user_id <> $1
+		)
+		ORDER BY o.name`, userID, auth.OrgRoleOwner)
+	if err != nil {
+		return err
+	}
+	var sole soleOwnerError
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		sole.orgs = append(sole.orgs, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(sole.orgs) > 0 {
+		return sole
+	}
+
+	// Login codes are keyed by number, so they go before the number does.
+	_, err = tx.Exec(`
+		DELETE FROM phone_otps WHERE phone IN (
+			SELECT phone_number FROM users WHERE id = $1 UNION SELECT phone FROM users WHERE id = $1
+		)`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users SET
+			email = 'deleted-' || id || '@deleted.invalid',
+			password = '!',
+			name = NULL,
+			phone = NULL,
+			phone_number = NULL,
+			avatar_url = NULL,
+			pending_email = NULL,
+			email_verified_at = NULL,
+			notification_preferences = '{"email": false, "sms": false, "event_updates": false, "marketing": false}',
+			deleted_at = NOW()
+		WHERE id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	for _, q := range []string{
+		`DELETE FROM user_identities WHERE user_id = $1`,
+		`DELETE FROM user_backup_codes WHERE user_id = $1`,
+		`DELETE FROM user_mfa WHERE user_id = $1`,
+		`DELETE FROM password_reset_tokens WHERE user_id = $1`,
+		`DELETE FROM calendar_feed_tokens WHERE user_id = $1`,
+		`DELETE FROM user_roles WHERE user_id = $1`,
+		`DELETE FROM organization_members WHERE user_id = $1`,
+		`UPDATE login_attempts SET email = 'deleted-' || user_id || '@deleted.invalid', ip_address = NULL, user_agent = NULL WHERE user_id = $1`,
+	} {
+		if _, err := tx.Exec(q, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"local-event-backend/auth"
+	"local-event-backend/utils"
+)
+
+type UserRoleRequest struct {
+	UserID int    `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+// GrantRoleHandler lets an admin give a user a role. It takes effect the
+// next time the user's token is refreshed.
+func GrantRoleHandler(c *gin.Context) {
+	var req UserRoleRequest
+	if err := bindInput(c, &req); err != nil || req.UserID == 0 || !auth.ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "A user_id and a valid role are required"})
+		return
+	}
+
+	if err := auth.GrantRole(utils.DB, req.UserID, req.Role); err != nil {
+		fmt.Println("❌ Grant Role Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not grant role"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role granted"})
+}
+
+func RevokeRoleHandler(c *gin.Context) {
+	var req UserRoleRequest
+	if err := bindInput(c, &req); err != nil || req.UserID == 0 || !auth.ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "A user_id and a valid role are required"})
+		return
+	}
+
+	if err := auth.RevokeRole(req.UserID, req.Role); err != nil {
+		fmt.Println("❌ Revoke Role Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not revoke role"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role revoked"})
+}
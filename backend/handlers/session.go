@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"local-event-backend/auth"
+)
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshTokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	UserID       int    `json:"user_id"`
+	Message      string `json:"message"`
+}
+
+func RefreshTokenHandler(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := bindInput(c, &req); err != nil || req.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "refresh_token is required"})
+		return
+	}
+
+	tokens, err := auth.Refresh(req.RefreshToken, c.Request.UserAgent(), c.ClientIP())
+	if errors.Is(err, auth.ErrRefreshTokenReused) {
+		fmt.Println("⚠️ Refresh token reuse detected, session family revoked")
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Session expired, please log in again"})
+		return
+	} else if errors.Is(err, auth.ErrInvalidRefreshToken) {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Session expired, please log in again"})
+		return
+	} else if err != nil {
+		fmt.Println("❌ Refresh Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, RefreshTokenResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+		UserID:       tokens.UserID,
+		Message:      "Token refreshed",
+	})
+}
+
+func LogoutHandler(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := bindInput(c, &req); err != nil || req.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "refresh_token is required"})
+		return
+	}
+
+	if err := auth.Logout(req.RefreshToken); err != nil {
+		fmt.Println("❌ Logout Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+func LogoutAllHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := auth.LogoutAll(userID); err != nil {
+		fmt.Println("❌ Logout-all Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions"})
+}
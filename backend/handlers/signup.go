@@ -1,10 +1,10 @@
 package handlers
 
 import (
-	"bytes"
 	"fmt"
-	"io"
+	"local-event-backend/auth"
 	"local-event-backend/utils"
+	"local-event-backend/validate"
 	"net/http"
 	"strings"
 
@@ -24,65 +24,101 @@ type SignupRequest struct {
 }
 
 type SignupResponse struct {
-	Token   string `json:"token"`
-	UserID  int    `json:"user_id"`
-	Message string `json:"message"` 
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in,omitempty"`
+	UserID       int    `json:"user_id"`
+	Message      string `json:"message"`
 }
 
 func SignupHandler(c *gin.Context) {
 	var req SignupRequest
 
-
-	raw, _ := io.ReadAll(c.Request.Body)
-	fmt.Println("🔍 HASURA SENT:", string(raw))
-	c.Request.Body = io.NopCloser(bytes.NewBuffer(raw))
-
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid JSON format"})
 		return
 	}
 
-	email := strings.TrimSpace(req.Input.Email)
-	password := req.Input.Password
-	fullName := fmt.Sprintf("%s %s", req.Input.FirstName, req.Input.LastName)
-	phone := req.Input.PhoneNumber
-
-	
-	if email == "" || password == "" {
-		fmt.Printf("❌ DATA MISSING: Email='%s', Password='%s'\n", email, password)
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Email and password are required"})
+	var errs fieldErrors
+	firstName := strings.TrimSpace(req.Input.FirstName)
+	lastName := strings.TrimSpace(req.Input.LastName)
+	if firstName == "" {
+		errs.add("first_name", "required", "First name is required")
+	}
+	email, err := validate.Email(req.Input.Email)
+	errs.check("email", err)
+	errs.check("password", validate.Password(req.Input.Password, email))
+	phone, err := validate.Phone(req.Input.PhoneNumber)
+	errs.check("phone_number", err)
+	if len(errs) > 0 {
+		respondFieldErrors(c, http.StatusBadRequest, errs)
 		return
 	}
+	fullName := strings.TrimSpace(firstName + " " + lastName)
 
-	
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashed, err := bcrypt.GenerateFromPassword([]byte(req.Input.Password), bcrypt.DefaultCost)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "Encryption failed"})
 		return
 	}
 
+	// The account and its role are created together, so a failed grant
+	// cannot leave behind a user who can never sign up again.
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
 	var userID int
-	query := `INSERT INTO users (email, password, name, phone_number) VALUES ($1, $2, $3, $4) RETURNING id`
-	err = utils.DB.QueryRow(query, email, string(hashed), fullName, phone).Scan(&userID)
+	query := `INSERT INTO users (email, password, name, phone_number) VALUES ($1, $2, $3, NULLIF($4, '')) RETURNING id`
+	err = tx.QueryRow(query, email, string(hashed), fullName, phone).Scan(&userID)
 
-	if err != nil {
+	if isUniqueViolation(err) {
+		var taken fieldErrors
+		if uniqueConstraint(err) == "users_phone_number_key" {
+			taken.add("phone_number", "phone_taken", "A user with this phone number already exists")
+		} else {
+			taken.add("email", "email_taken", "A user with this email already exists")
+		}
+		respondFieldErrors(c, http.StatusConflict, taken)
+		return
+	} else if err != nil {
 		fmt.Println("❌ DB ERROR:", err)
-		c.JSON(http.StatusBadRequest, gin.H{"message": "A user with this email already exists"})
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
 		return
 	}
 
-	
-	token, err := utils.GenerateToken(userID)
+	if err := auth.GrantRole(tx, userID, auth.RoleAttendee); err != nil {
+		fmt.Println("❌ Role Grant Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		fmt.Println("❌ Transaction commit failed:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	// Only mail once the account exists for the link to verify.
+	if err := sendVerificationEmail(userID, email); err != nil {
+		fmt.Println("⚠️ Verification Mail Warning:", err)
+	}
+
+	tokens, err := auth.StartSession(userID, req.Input.RememberMe, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "Token generation failed"})
 		return
 	}
 
-	
 	fmt.Printf("✅ Success! Created User %d\n", userID)
 	c.JSON(http.StatusOK, SignupResponse{
-		Token:   token,
-		UserID:  userID,
-		Message: "User registered successfully", // <--- Matches 'message' in Hasura
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+		UserID:       userID,
+		Message:      "User registered successfully", // <--- Matches 'message' in Hasura
 	})
-}
\ No newline at end of file
+}
@@ -1,172 +1,204 @@
 package handlers
 
 import (
-	"bytes"
-	"encoding/json"
+	"database/sql"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
+	"local-event-backend/payments"
+	"local-event-backend/tickets"
 	"local-event-backend/utils"
 )
 
 type PurchaseTicketRequest struct {
-	EventID  int     `json:"event_id" binding:"required"`
-	TicketID int     `json:"ticket_id" binding:"required"`
-	Quantity int     `json:"quantity" binding:"required"`
-	Amount   float64 `json:"amount" binding:"required"`
+	EventID      int `json:"event_id" binding:"required"`
+	TicketTypeID int `json:"ticket_type_id"`
+	// TicketID is the old name of TicketTypeID.
+	TicketID int `json:"ticket_id"`
+	Quantity int `json:"quantity" binding:"required"`
+	// OccurrenceID picks the date of a recurring event; it is required for
+	// those and ignored otherwise.
+	OccurrenceID *int `json:"occurrence_id"`
 }
 
 type PurchaseTicketResponse struct {
 	Status        string    `json:"status"`
 	ReservationID int       `json:"reservation_id"`
+	Amount        float64   `json:"amount"`
+	Currency      string    `json:"currency"`
 	CheckoutURL   string    `json:"checkout_url,omitempty"`
 	CreatedAt     time.Time `json:"created_at"`
+	// ExpiresAt is when an unpaid reservation is released.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
+// PurchaseTicketHandler reserves tickets and starts payment. The amount is
+// always computed from the ticket type; clients cannot set it.
 func PurchaseTicketHandler(c *gin.Context) {
-	userID, ok := getUserIDFromAuthHeaderTicket(c)
+	userID, ok := currentUserID(c)
 	if !ok {
 		return
 	}
 
 	var req PurchaseTicketRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := bindInput(c, &req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
 		return
 	}
 
-	if req.Quantity <= 0 || req.Amount <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Quantity/Amount must be greater than zero"})
+	response, ok := checkout(c, userID, req, loadCustomer(userID), "/events/reserved")
+	if !ok {
 		return
 	}
+	c.JSON(http.StatusOK, response)
+}
 
-	var reservationID int
-	var userEmail string
-	_ = utils.DB.QueryRow(`SELECT email FROM users WHERE id=$1`, userID).Scan(&userEmail)
+// loadCustomer describes userID to the payment provider.
+func loadCustomer(userID int) payments.Customer {
+	var email, name, phone sql.NullString
+	_ = utils.DB.QueryRow(
+		`SELECT email, name, phone_number FROM users WHERE id = $1`, userID,
+	).Scan(&email, &name, &phone)
 
-	err := utils.DB.QueryRow(`
-		INSERT INTO ticket_sales (event_id, ticket_id, user_id, quantity, amount, status, created_at)
-		VALUES ($1, $2, $3, $4, $5, 'pending', NOW())
-		RETURNING id
-	`, req.EventID, req.TicketID, userID, req.Quantity, req.Amount).Scan(&reservationID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
-		return
+	customer := payments.Customer{
+		Email:     email.String,
+		FirstName: fmt.Sprintf("user-%d", userID),
+		LastName:  "buyer",
+		Phone:     phone.String,
 	}
-
-	checkoutURL, txRef, err := initiateChapaPayment(req.Amount, userEmail, fmt.Sprintf("user-%d", userID), "buyer", reservationID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
-		return
+	if parts := strings.Fields(name.String); len(parts) > 0 {
+		customer.FirstName = parts[0]
+		customer.LastName = strings.Join(parts[1:], " ")
 	}
+	return customer
+}
 
-	_, _ = utils.DB.Exec(`UPDATE ticket_sales SET tx_ref=$1, checkout_url=$2 WHERE id=$3`, txRef, checkoutURL, reservationID)
-
-	c.JSON(http.StatusOK, PurchaseTicketResponse{
-		Status:        "pending",
-		ReservationID: reservationID,
-		CheckoutURL:   checkoutURL,
-		CreatedAt:     time.Now(),
-	})
+// paymentCallbackURL is where the provider reports settled payments.
+func paymentCallbackURL() string {
+	if u := os.Getenv("CHAPA_CALLBACK_URL"); u != "" {
+		return u
+	}
+	return publicURL() + "/webhook/chapa"
 }
 
-func getUserIDFromAuthHeaderTicket(c *gin.Context) (int, bool) {
-	authHeader := c.GetHeader("Authorization")
-	if authHeader == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "Missing Authorization header"})
-		return 0, false
+// checkout reserves req's tickets for userID and opens a payment for them
+// through payments.Default; the buyer comes back to returnPath on the
+// frontend. Free orders complete at once. On failure it writes the error
+// response itself and returns false.
+func checkout(c *gin.Context, userID int, req PurchaseTicketRequest, customer payments.Customer, returnPath string) (PurchaseTicketResponse, bool) {
+	var response PurchaseTicketResponse
+	if req.Quantity <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Quantity must be greater than zero"})
+		return response, false
 	}
-	tokenString := authHeader[len("Bearer "):]
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return utils.JwtSecret, nil
-	})
-	if err != nil || !token.Valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid token"})
-		return 0, false
+	if req.TicketTypeID == 0 {
+		req.TicketTypeID = req.TicketID
 	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid token claims"})
-		return 0, false
+
+	if !requireOnSale(c, req.EventID) {
+		return response, false
 	}
-	hasura, ok := claims["https://hasura.io/jwt/claims"].(map[string]interface{})
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "Missing Hasura claims"})
-		return 0, false
+
+	var recurring bool
+	if err := utils.DB.QueryRow(`SELECT rrule IS NOT NULL FROM events WHERE id = $1`, req.EventID).Scan(&recurring); err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return response, false
 	}
-	userIDStr, ok := hasura["x-hasura-user-id"].(string)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "Missing user id claim"})
-		return 0, false
+	if !recurring {
+		req.OccurrenceID = nil
+	} else if req.OccurrenceID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "occurrence_id is required for a recurring event"})
+		return response, false
 	}
-	return mustAtoi(userIDStr), true
-}
 
-type chapaInitResponse struct {
-	Status string `json:"status"`
-	Data   struct {
-		CheckoutURL string `json:"checkout_url"`
-		Reference   string `json:"reference"`
-	} `json:"data"`
-}
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return response, false
+	}
+	defer tx.Rollback()
 
-func initiateChapaPayment(amount float64, email, firstName, lastName string, reservationID int) (string, string, error) {
-	secret := os.Getenv("CHAPA_SECRET")
-	callbackURL := os.Getenv("CHAPA_CALLBACK_URL")
-	returnURL := os.Getenv("CHAPA_RETURN_URL")
-	if secret == "" {
-		return "", "", fmt.Errorf("CHAPA_SECRET is not set")
+	quote, errs, err := quoteTickets(tx, req.EventID, req.TicketTypeID, req.Quantity)
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return response, false
+	}
+	if len(errs) > 0 {
+		respondFieldErrors(c, http.StatusBadRequest, errs)
+		return response, false
+	}
+
+	reservationID, status, err := tickets.Reserve(tx, tickets.Hold{
+		EventID:      req.EventID,
+		TicketTypeID: quote.TicketTypeID,
+		OccurrenceID: req.OccurrenceID,
+		UserID:       userID,
+		Quantity:     req.Quantity,
+		UnitPrice:    quote.UnitPrice,
+		Amount:       quote.Amount,
+		Currency:     quote.Currency,
+	})
+	if err == tickets.ErrSoldOut || err == tickets.ErrDateSoldOut {
+		c.JSON(http.StatusConflict, gin.H{"message": "Sorry, " + err.Error()})
+		return response, false
 	}
-	if callbackURL == "" {
-		callbackURL = "http://localhost:8082/chapa/webhook"
+	// The reference is stored before the provider hears of it, so every
+	// webhook can be matched to its sale.
+	txRef := fmt.Sprintf("tx-%d-%d", reservationID, time.Now().Unix())
+	if err == nil && status == tickets.StatusPending {
+		_, err = tx.Exec(`UPDATE ticket_sales SET tx_ref = $1, payment_provider = $2 WHERE id = $3`,
+			txRef, payments.Default.Name(), reservationID)
 	}
-	if returnURL == "" {
-		returnURL = "http://localhost:3000/events/reserved"
+	if err == nil {
+		err = tx.Commit()
 	}
-
-	txRef := fmt.Sprintf("tx-%d-%d", reservationID, time.Now().Unix())
-	payload := map[string]interface{}{
-		"amount":       fmt.Sprintf("%.2f", amount),
-		"currency":     "ETB",
-		"email":        email,
-		"first_name":   firstName,
-		"last_name":    lastName,
-		"tx_ref":       txRef,
-		"callback_url": callbackURL,
-		"return_url":   returnURL,
-		"customization": map[string]string{
-			"title":       "Event Ticket",
-			"description": "Local event ticket purchase",
-		},
-	}
-
-	body, _ := json.Marshal(payload)
-	req, _ := http.NewRequest("POST", "https://api.chapa.co/v1/transaction/initialize", bytes.NewBuffer(body))
-	req.Header.Set("Authorization", "Bearer "+secret)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	res, err := client.Do(req)
 	if err != nil {
-		return "", "", err
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return response, false
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode >= 300 {
-		return "", "", fmt.Errorf("chapa init failed: %s", res.Status)
+	response = PurchaseTicketResponse{
+		Status:        status,
+		ReservationID: reservationID,
+		Amount:        quote.Amount,
+		Currency:      quote.Currency,
+		CreatedAt:     time.Now(),
 	}
-
-	var resp chapaInitResponse
-	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
-		return "", "", err
+	if status == tickets.StatusCompleted {
+		return response, true
 	}
-	if resp.Status != "success" {
-		return "", "", fmt.Errorf("chapa init status: %s", resp.Status)
+
+	opened, err := payments.Default.Initialize(c.Request.Context(), payments.InitializeRequest{
+		TxRef:       txRef,
+		Amount:      quote.Amount,
+		Currency:    quote.Currency,
+		Customer:    customer,
+		CallbackURL: paymentCallbackURL(),
+		ReturnURL:   appURL() + returnPath,
+		Title:       "Event Ticket",
+		Description: "Local event ticket purchase",
+	})
+	if err != nil {
+		fmt.Println("❌ Payment Init Error:", err)
+		if _, rerr := tickets.Release(utils.DB, reservationID, tickets.StatusFailed); rerr != nil {
+			fmt.Println("❌ DB ERROR:", rerr)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return response, false
 	}
-	return resp.Data.CheckoutURL, resp.Data.Reference, nil
-}
 
+	_, _ = utils.DB.Exec(`UPDATE ticket_sales SET checkout_url=$1 WHERE id=$2`, opened.CheckoutURL, reservationID)
+
+	response.CheckoutURL = opened.CheckoutURL
+	expires := response.CreatedAt.Add(tickets.HoldTTL())
+	response.ExpiresAt = &expires
+	return response, true
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"local-event-backend/auth"
+	"local-event-backend/payments"
+	"local-event-backend/testdb"
+	"local-event-backend/utils"
+)
+
+const testWebhookSecret = "test-webhook-secret"
+
+// paymentTest is a database, a fake provider and a router with the
+// payment endpoints, standing in for main's globals for one test.
+type paymentTest struct {
+	db     *sql.DB
+	fake   *payments.Fake
+	router *gin.Engine
+	userID int
+}
+
+func newPaymentTest(t *testing.T) *paymentTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db := testdb.Open(t)
+	fake := payments.NewFake()
+	fake.WebhookSecret = testWebhookSecret
+
+	prevDB, prevProvider := utils.DB, payments.Default
+	utils.DB, payments.Default = db, fake
+	t.Cleanup(func() { utils.DB, payments.Default = prevDB, prevProvider })
+
+	pt := &paymentTest{db: db, fake: fake, router: gin.New()}
+	err := db.QueryRow(`
+		INSERT INTO users (email, password, name, email_verified_at)
+		VALUES ('buyer@example.com', '!', 'Test Buyer', NOW()) RETURNING id`).Scan(&pt.userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pt.router.POST("/purchase-ticket", func(c *gin.Context) {
+		c.Set(principalKey, auth.Principal{UserID: pt.userID, Role: auth.RoleAttendee})
+	}, PurchaseTicketHandler)
+	pt.router.POST("/webhook/chapa", ChapaWebhookHandler)
+	return pt
+}
+
+func (pt *paymentTest) post(t *testing.T, path string, body []byte, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	pt.router.ServeHTTP(w, req)
+	return w
+}
+
+// purchase checks quantity tickets of typeID out through the handler and
+// returns the response and the sale's tx_ref.
+func (pt *paymentTest) purchase(t *testing.T, eventID, typeID, quantity int) (PurchaseTicketResponse, string) {
+	t.Helper()
+	body, _ := json.Marshal(gin.H{"input": gin.H{
+		"event_id": eventID, "ticket_type_id": typeID, "quantity": quantity,
+	}})
+	w := pt.post(t, "/purchase-ticket", body, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("purchase answered %d: %s", w.Code, w.Body)
+	}
+	var resp PurchaseTicketResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	var txRef sql.NullString
+	if err := pt.db.QueryRow(`SELECT tx_ref FROM ticket_sales WHERE id = $1`, resp.ReservationID).Scan(&txRef); err != nil {
+		t.Fatal(err)
+	}
+	return resp, txRef.String
+}
+
+func (pt *paymentTest) saleStatus(t *testing.T, saleID int) string {
+	t.Helper()
+	var status string
+	if err := pt.db.QueryRow(`SELECT status FROM ticket_sales WHERE id = $1`, saleID).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func (pt *paymentTest) count(t *testing.T, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := pt.db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestCheckoutReservesAndOpensPayment(t *testing.T) {
+	pt := newPaymentTest(t)
+	quantity := 10
+	eventID := testdb.Event(t, pt.db)
+	typeID := testdb.TicketType(t, pt.db, eventID, 150, &quantity)
+
+	resp, txRef := pt.purchase(t, eventID, typeID, 2)
+	if resp.Status != "pending" || resp.Amount != 300 || resp.Currency != "ETB" {
+		t.Fatalf("response = %+v, want a pending 300 ETB order", resp)
+	}
+	if resp.CheckoutURL == "" || resp.ExpiresAt == nil {
+		t.Fatalf("response = %+v, want a checkout URL and an expiry", resp)
+	}
+	if txRef == "" {
+		t.Fatal("sale has no tx_ref")
+	}
+
+	txn, err := pt.fake.Verify(context.Background(), txRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txn.Status != payments.StatusPending || txn.Amount != 300 || txn.Currency != "ETB" {
+		t.Fatalf("provider transaction = %+v, want a pending 300 ETB payment", txn)
+	}
+	if sold := pt.count(t, `SELECT sold FROM ticket_types WHERE id = $1`, typeID); sold != 2 {
+		t.Fatalf("ticket type sold = %d, want 2 held", sold)
+	}
+}
+
+func TestCheckoutRefusesMoreThanAvailable(t *testing.T) {
+	pt := newPaymentTest(t)
+	quantity := 1
+	eventID := testdb.Event(t, pt.db)
+	typeID := testdb.TicketType(t, pt.db, eventID, 150, &quantity)
+
+	body, _ := json.Marshal(gin.H{"input": gin.H{
+		"event_id": eventID, "ticket_type_id": typeID, "quantity": 2,
+	}})
+	if w := pt.post(t, "/purchase-ticket", body, nil); w.Code != http.StatusConflict {
+		t.Fatalf("purchase answered %d, want 409: %s", w.Code, w.Body)
+	}
+	if n := pt.count(t, `SELECT COUNT(*) FROM ticket_sales`); n != 0 {
+		t.Fatalf("%d sales recorded, want none", n)
+	}
+}
+
+func TestCheckoutClosesWhenEventStarts(t *testing.T) {
+	pt := newPaymentTest(t)
+	eventID := testdb.Event(t, pt.db)
+	typeID := testdb.TicketType(t, pt.db, eventID, 150, nil)
+	_, err := pt.db.Exec(`
+		UPDATE events SET starts_at = NOW() - interval '1 hour', ends_at = NOW() + interval '1 hour'
+		WHERE id = $1`, eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(gin.H{"input": gin.H{
+		"event_id": eventID, "ticket_type_id": typeID, "quantity": 1,
+	}})
+	w := pt.post(t, "/purchase-ticket", body, nil)
+	if w.Code != http.StatusBadRequest || !bytes.Contains(w.Body.Bytes(), []byte("sales_ended")) {
+		t.Fatalf("purchase answered %d (%s), want 400 sales_ended", w.Code, w.Body)
+	}
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -29,44 +30,45 @@ func UploadHandler(c *gin.Context) {
 		return
 	}
 
-	
+	fileURL, err := saveUpload(req.Input.Name, req.Input.Base64)
+	if err != nil {
+		fmt.Println("❌ Upload Error:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	fmt.Println("✅ File Uploaded Successfully:", fileURL)
+	c.JSON(http.StatusOK, UploadResponse{URL: fileURL})
+}
+
+// saveUpload decodes base64 data, writes it under uploads/ with a unique name
+// keeping the extension of name, and returns its public URL.
+func saveUpload(name, data string) (string, error) {
 	uploadDir := "uploads"
 	if _, err := os.Stat(uploadDir); os.IsNotExist(err) {
-		err := os.MkdirAll(uploadDir, os.ModePerm)
-		if err != nil {
+		if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
 			fmt.Println("❌ Error creating directory:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal directory error"})
-			return
+			return "", errors.New("Internal directory error")
 		}
 	}
 
-	
-	dec, err := base64.StdEncoding.DecodeString(req.Input.Base64)
+	dec, err := base64.StdEncoding.DecodeString(data)
 	if err != nil {
 		fmt.Println("❌ Base64 Decode Error:", err)
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid base64 data"})
-		return
+		return "", errors.New("Invalid base64 data")
 	}
 
-	
-	extension := filepath.Ext(req.Input.Name)
+	extension := filepath.Ext(name)
 	if extension == "" {
 		extension = ".png"
 	}
 	newFileName := fmt.Sprintf("%d%s", time.Now().UnixNano(), extension)
 	uploadPath := filepath.Join(uploadDir, newFileName)
 
-	
-	err = os.WriteFile(uploadPath, dec, 0644)
-	if err != nil {
+	if err := os.WriteFile(uploadPath, dec, 0644); err != nil {
 		fmt.Println("❌ File Save Error:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not save file to disk"})
-		return
+		return "", errors.New("Could not save file to disk")
 	}
 
-	
-	fileURL := fmt.Sprintf("http://localhost:8082/uploads/%s", newFileName)
-
-	fmt.Println("✅ File Uploaded Successfully:", fileURL)
-	c.JSON(http.StatusOK, UploadResponse{URL: fileURL})
-}
\ No newline at end of file
+	return fmt.Sprintf("http://localhost:8082/uploads/%s", newFileName), nil
+}
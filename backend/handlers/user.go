@@ -6,7 +6,9 @@ import (
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 
+	"local-event-backend/auth"
 	"local-event-backend/utils"
+	"local-event-backend/validate"
 )
 
 type CreateUserRequest struct {
@@ -16,11 +18,20 @@ type CreateUserRequest struct {
 
 func CreateUserHandler(c *gin.Context) {
 	var req CreateUserRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := bindInput(c, &req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request"})
 		return
 	}
 
+	var errs fieldErrors
+	email, err := validate.Email(req.Email)
+	errs.check("email", err)
+	errs.check("password", validate.Password(req.Password, email))
+	if len(errs) > 0 {
+		respondFieldErrors(c, http.StatusBadRequest, errs)
+		return
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword(
 		[]byte(req.Password),
 		bcrypt.DefaultCost,
@@ -30,14 +41,23 @@ func CreateUserHandler(c *gin.Context) {
 		return
 	}
 
-	_, err = utils.DB.Exec(
-		`INSERT INTO users (email, password) VALUES ($1, $2)`,
-		req.Email,
+	var userID int
+	err = utils.DB.QueryRow(
+		`INSERT INTO users (email, password) VALUES ($1, $2) RETURNING id`,
+		email,
 		string(hashedPassword),
-	)
+	).Scan(&userID)
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "User already exists"})
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"message": "User already exists"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+		return
+	}
+
+	if err := auth.GrantRole(utils.DB, userID, auth.RoleAttendee); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Role error"})
 		return
 	}
 
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"local-event-backend/validate"
+)
+
+// FieldError describes why one input field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// fieldErrors collects rejected fields while a request is validated.
+type fieldErrors []FieldError
+
+func (e *fieldErrors) add(field, code, message string) {
+	*e = append(*e, FieldError{Field: field, Code: code, Message: message})
+}
+
+// check records err against field when it is non-nil.
+func (e *fieldErrors) check(field string, err error) {
+	if err == nil {
+		return
+	}
+	var verr *validate.Error
+	if errors.As(err, &verr) {
+		e.add(field, verr.Code, verr.Message)
+		return
+	}
+	e.add(field, "invalid", err.Error())
+}
+
+// respondFieldErrors answers in the shape Hasura passes through from an
+// action: a top-level message plus per-field codes under extensions.
+func respondFieldErrors(c *gin.Context, status int, errs fieldErrors) {
+	c.JSON(status, gin.H{
+		"message": errs[0].Message,
+		"extensions": gin.H{
+			"code":   "validation-failed",
+			"fields": errs,
+		},
+	})
+}
+
+// isUniqueViolation reports whether err is Postgres' unique_violation.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// uniqueConstraint returns the constraint or index named by a unique_violation,
+// or "" when err is something else.
+func uniqueConstraint(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return pqErr.Constraint
+	}
+	return ""
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"local-event-backend/auth"
+	"local-event-backend/mailer"
+	"local-event-backend/utils"
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// appURL is the frontend base URL used in links sent by email.
+func appURL() string {
+	if u := os.Getenv("APP_URL"); u != "" {
+		return u
+	}
+	return "http://localhost:3000"
+}
+
+func sendVerificationEmail(userID int, email string) error {
+	token, err := auth.NewEmailVerificationToken(userID, email)
+	if err != nil {
+		return err
+	}
+	link := fmt.Sprintf("%s/auth/verify-email?token=%s", appURL(), url.QueryEscape(token))
+	return mailer.Default.Send(mailer.Message{
+		To:      email,
+		Subject: "Verify your Minab email address",
+		Body:    "Welcome to Minab! Confirm your email address by opening the link below. It expires in 24 hours.\n\n" + link,
+	})
+}
+
+func VerifyEmailHandler(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := bindInput(c, &req); err != nil || req.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "token is required"})
+		return
+	}
+
+	userID, email, err := auth.ParseEmailVerificationToken(req.Token)
+	if err != nil {
+		fmt.Println("❌ Verification Token Error:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "This verification link is invalid or has expired"})
+		return
+	}
+
+	res, err := utils.DB.Exec(
+		`UPDATE users SET email_verified_at = NOW() WHERE id = $1 AND email = $2 AND email_verified_at IS NULL`,
+		userID, email,
+	)
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// The link may confirm an address requested through /me instead.
+		res, err := utils.DB.Exec(
+			`UPDATE users SET email = pending_email, pending_email = NULL, email_verified_at = NOW()
+			 WHERE id = $1 AND pending_email = $2 AND deleted_at IS NULL`,
+			userID, email,
+		)
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"message": "This email address is already in use"})
+			return
+		} else if err != nil {
+			fmt.Println("❌ DB ERROR:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			c.JSON(http.StatusOK, gin.H{"message": "Email changed"})
+			return
+		}
+
+		var verified bool
+		err = utils.DB.QueryRow(
+			`SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1 AND email = $2`,
+			userID, email,
+		).Scan(&verified)
+		if err != nil || !verified {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "This verification link is invalid or has expired"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Email already verified"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+func ResendVerificationHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var email string
+	var verifiedAt sql.NullTime
+	err := utils.DB.QueryRow(`SELECT email, email_verified_at FROM users WHERE id = $1`, userID).Scan(&email, &verifiedAt)
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	if verifiedAt.Valid {
+		c.JSON(http.StatusOK, gin.H{"message": "Email already verified"})
+		return
+	}
+
+	if err := sendVerificationEmail(userID, email); err != nil {
+		fmt.Println("❌ Mail Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
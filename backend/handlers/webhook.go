@@ -1,50 +1,125 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"local-event-backend/payments"
+	"local-event-backend/tickets"
 	"local-event-backend/utils"
+	"math"
 	"net/http"
-	"strconv" 
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-type ChapaWebhookPayload struct {
-	Status    string  `json:"status"`
-	Amount    float64 `json:"amount"`
-	TxRef     string  `json:"tx_ref"`
-	Reference string  `json:"reference"`
-}
+const (
+	// maxWebhookBody bounds what a delivery may send; real ones are small.
+	maxWebhookBody = 64 << 10
+	// maxRejectedPayload is how much of a refused delivery is kept.
+	maxRejectedPayload = 2 << 10
+)
 
+// ChapaWebhookHandler settles a sale when the provider reports its payment.
+// Deliveries must be signed, and a success is only trusted once the
+// provider's own record of the transaction agrees with the sale on status,
+// amount and currency.
 func ChapaWebhookHandler(c *gin.Context) {
-	var payload ChapaWebhookPayload
-	if err := c.ShouldBindJSON(&payload); err != nil {
-		fmt.Println("❌ Webhook JSON Error:", err)
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookBody)
+	body, err := c.GetRawData()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": "Webhook body too large"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid webhook data"})
 		return
 	}
+	payload, err := payments.Default.ParseWebhook(c.Request.Header, body)
+	if errors.Is(err, payments.ErrBadSignature) {
+		rejectWebhook(c, http.StatusUnauthorized, "", "bad signature", body)
+		return
+	}
+	if err != nil {
+		fmt.Println("❌ Webhook JSON Error:", err)
+		rejectWebhook(c, http.StatusBadRequest, "", "unreadable payload", body)
+		return
+	}
 
 	fmt.Printf("🔔 Received Webhook for Ref: %s (Status: %s)\n", payload.TxRef, payload.Status)
 
-	if payload.Status != "success" {
-		c.JSON(http.StatusOK, gin.H{"message": "Payment not successful, no ticket issued"})
+	// Record the delivery first. Retries of a delivery already handled are
+	// acknowledged without touching the sale again.
+	eventID, processed, err := recordPaymentEvent(payload, body)
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+		return
+	}
+	if processed {
+		c.JSON(http.StatusOK, gin.H{"message": "Webhook already processed"})
 		return
 	}
 
-	parts := strings.Split(payload.TxRef, "-")
-	if len(parts) < 5 {
-		fmt.Println("❌ Error: Invalid tx_ref format received")
-		c.JSON(http.StatusOK, gin.H{"message": "Invalid reference format"})
+	var saleID int
+	var amount float64
+	var currency, status string
+	err = utils.DB.QueryRow(`
+		SELECT id, amount, COALESCE(currency, 'ETB'), status
+		FROM ticket_sales WHERE tx_ref = $1`, payload.TxRef).
+		Scan(&saleID, &amount, &currency, &status)
+	if err == sql.ErrNoRows {
+		finishPaymentEvent(utils.DB, eventID, 0, "rejected: unknown tx_ref")
+		rejectWebhook(c, http.StatusOK, payload.TxRef, "unknown tx_ref", body)
+		return
+	}
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
 		return
 	}
 
-	eventID, errEv := strconv.Atoi(parts[2]) 
-	userID, errUsr := strconv.Atoi(parts[4]) 
+	if payload.Status != payments.StatusSuccess {
+		result := "ignored: " + string(payload.Status)
+		if payload.Status == payments.StatusFailed {
+			released, err := tickets.Release(utils.DB, saleID, tickets.StatusFailed)
+			if err != nil {
+				fmt.Println("❌ Error releasing hold:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+				return
+			}
+			if released {
+				result = "failed"
+			}
+		}
+		finishPaymentEvent(utils.DB, eventID, saleID, result)
+		c.JSON(http.StatusOK, gin.H{"message": "Payment not successful, no ticket issued"})
+		return
+	}
+	if status == tickets.StatusCompleted {
+		finishPaymentEvent(utils.DB, eventID, saleID, "ignored: already completed")
+		c.JSON(http.StatusOK, gin.H{"message": "Webhook already processed"})
+		return
+	}
 
-	if errEv != nil || errUsr != nil {
-		fmt.Println("❌ Error converting IDs to integers")
-		c.JSON(http.StatusOK, gin.H{"message": "ID conversion error"})
+	// Ask the provider rather than believing the webhook body.
+	txn, err := payments.Default.Verify(c.Request.Context(), payload.TxRef)
+	if errors.Is(err, payments.ErrNotFound) {
+		finishPaymentEvent(utils.DB, eventID, saleID, "rejected: transaction not found at provider")
+		rejectWebhook(c, http.StatusBadRequest, payload.TxRef, "transaction not found at provider", body)
+		return
+	}
+	if err != nil {
+		// Leave the event unprocessed and answer with an error so the
+		// provider retries the delivery.
+		fmt.Println("❌ Payment verification failed:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"message": "Could not verify payment"})
+		return
+	}
+	if reason := verifyMismatch(txn, amount, currency); reason != "" {
+		finishPaymentEvent(utils.DB, eventID, saleID, "rejected: "+reason)
+		rejectWebhook(c, http.StatusBadRequest, payload.TxRef, reason, body)
 		return
 	}
 
@@ -55,26 +130,39 @@ func ChapaWebhookHandler(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
-	var saleID int
-	err = tx.QueryRow(`
-		INSERT INTO ticket_sales (user_id, event_id, amount, status, tx_ref)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id`,
-		userID, eventID, payload.Amount, "completed", payload.TxRef,
-	).Scan(&saleID)
+	// pending -> completed happens at most once, however often the
+	// provider delivers: the row is locked and only moved from pending.
+	if err = tx.QueryRow(`SELECT status FROM ticket_sales WHERE id = $1 FOR UPDATE`, saleID).Scan(&status); err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+		return
+	}
+	switch status {
+	case tickets.StatusPending:
+	case tickets.StatusCompleted:
+		finishPaymentEvent(tx, eventID, saleID, "ignored: already completed")
+		tx.Commit()
+		c.JSON(http.StatusOK, gin.H{"message": "Webhook already processed"})
+		return
+	default:
+		tx.Rollback()
+		refundLatePayment(c, eventID, saleID, payload.TxRef)
+		return
+	}
 
+	_, err = tx.Exec(`
+		UPDATE ticket_sales SET status = 'completed', expires_at = NULL, payment_reference = NULLIF($2, '')
+		WHERE id = $1`,
+		saleID, txn.Reference)
 	if err != nil {
 		fmt.Println("❌ Error saving to ticket_sales:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to save sale"})
 		return
 	}
 
-	_, err = tx.Exec(`
-		INSERT INTO tickets (user_id, event_id, ticket_number, status)
-		VALUES ($1, $2, $3, $4)`,
-		userID, eventID, "TKT-"+payload.TxRef, "active",
-	)
-
+	if err = tickets.Issue(tx, saleID); err == nil {
+		err = finishPaymentEvent(tx, eventID, saleID, "completed")
+	}
 	if err != nil {
 		fmt.Println("❌ Error saving to tickets:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to issue ticket"})
@@ -87,6 +175,111 @@ func ChapaWebhookHandler(c *gin.Context) {
 		return
 	}
 
-	fmt.Printf("✅ SUCCESS! Ticket issued to User %d for Event %d\n", userID, eventID)
+	fmt.Printf("✅ SUCCESS! Tickets issued for sale %d\n", saleID)
 	c.JSON(http.StatusOK, gin.H{"message": "Webhook processed successfully"})
-}
\ No newline at end of file
+}
+
+// recordPaymentEvent stores a delivery in the payment_events ledger, keyed
+// by the provider's reference and event type. A redelivery only bumps
+// attempts; processed reports whether an earlier delivery was handled.
+func recordPaymentEvent(ev payments.WebhookEvent, body []byte) (int64, bool, error) {
+	reference := ev.Reference
+	if reference == "" {
+		reference = ev.TxRef
+	}
+	eventType := ev.Type
+	if eventType == "" {
+		eventType = string(ev.Status)
+	}
+	var id int64
+	var processedAt sql.NullTime
+	err := utils.DB.QueryRow(`
+		INSERT INTO payment_events (provider, reference, event_type, tx_ref, status, amount, currency, payload)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (provider, reference, event_type) DO UPDATE SET attempts = payment_events.attempts + 1
+		RETURNING id, processed_at`,
+		payments.Default.Name(), reference, eventType, ev.TxRef, string(ev.Status), ev.Amount, ev.Currency, string(body),
+	).Scan(&id, &processedAt)
+	return id, processedAt.Valid, err
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// finishPaymentEvent marks a ledger entry handled with what came of it.
+func finishPaymentEvent(db execer, eventID int64, saleID int, result string) error {
+	var sale sql.NullInt64
+	if saleID > 0 {
+		sale = sql.NullInt64{Int64: int64(saleID), Valid: true}
+	}
+	_, err := db.Exec(`
+		UPDATE payment_events SET sale_id = $2, result = $3, processed_at = NOW()
+		WHERE id = $1`, eventID, sale, result)
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+	}
+	return err
+}
+
+// refundLatePayment handles a verified payment for a sale that is no longer
+// pending, because its hold expired or failed first. Its seats may have
+// been sold to someone else, so the money is returned instead.
+func refundLatePayment(c *gin.Context, eventID int64, saleID int, txRef string) {
+	refund, err := payments.Default.Refund(c.Request.Context(), payments.RefundRequest{
+		TxRef:     txRef,
+		Reason:    "Reservation expired before payment completed",
+		Reference: fmt.Sprintf("refund-%d", saleID),
+	})
+	if err != nil {
+		// Unprocessed, so the provider's retry tries the refund again.
+		fmt.Println("❌ Refund of late payment failed:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"message": "Could not refund late payment"})
+		return
+	}
+	finishPaymentEvent(utils.DB, eventID, saleID, "refunded: hold ended before payment ("+string(refund.Status)+")")
+	fmt.Printf("↩️ Refunded late payment for sale %d\n", saleID)
+	c.JSON(http.StatusOK, gin.H{"message": "Reservation expired, payment refunded"})
+}
+
+// verifyMismatch compares the provider's transaction with the sale and
+// says what is wrong, or returns "" when they agree.
+func verifyMismatch(txn payments.Transaction, amount float64, currency string) string {
+	switch {
+	case txn.Status != payments.StatusSuccess:
+		return fmt.Sprintf("provider status is %s", txn.Status)
+	case math.Abs(txn.Amount-amount) > 0.005:
+		return fmt.Sprintf("amount %.2f does not match sale amount %.2f", txn.Amount, amount)
+	case !strings.EqualFold(txn.Currency, currency):
+		return fmt.Sprintf("currency %s does not match sale currency %s", txn.Currency, currency)
+	}
+	return ""
+}
+
+// rejectWebhook records a refused delivery in payment_webhook_rejections,
+// keeping only the start of its body, and answers it with status.
+func rejectWebhook(c *gin.Context, status int, txRef, reason string, body []byte) {
+	fmt.Printf("⛔ Webhook rejected (%s): %s\n", txRef, reason)
+	signature := ""
+	for _, name := range payments.SignatureHeaders {
+		if signature = c.GetHeader(name); signature != "" {
+			break
+		}
+	}
+	if len(signature) > 256 {
+		signature = signature[:256]
+	}
+	if len(body) > maxRejectedPayload {
+		body = body[:maxRejectedPayload]
+	}
+	_, err := utils.DB.Exec(`
+		INSERT INTO payment_webhook_rejections (provider, tx_ref, reason, ip_address, signature, payload)
+		VALUES ($1, NULLIF($2, ''), $3, $4, NULLIF($5, ''), $6)`,
+		payments.Default.Name(), txRef, reason, c.ClientIP(), signature, strings.ToValidUTF8(string(body), ""),
+	)
+	if err != nil {
+		fmt.Println("❌ DB ERROR:", err)
+	}
+	c.JSON(status, gin.H{"message": "Webhook rejected"})
+}
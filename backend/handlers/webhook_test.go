@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"local-event-backend/payments"
+	"local-event-backend/testdb"
+	"local-event-backend/tickets"
+)
+
+// deliver posts a webhook for txRef with status, signed like Chapa's.
+func (pt *paymentTest) deliver(t *testing.T, txRef, status string, amount float64) (int, string) {
+	t.Helper()
+	body, _ := json.Marshal(gin.H{
+		"event": "charge." + status, "tx_ref": txRef, "reference": "REF-" + txRef,
+		"status": status, "amount": amount, "currency": "ETB",
+	})
+	header := http.Header{}
+	header.Set(payments.SignatureHeaders[0], payments.Sign(testWebhookSecret, body))
+	w := pt.post(t, "/webhook/chapa", body, header)
+	var resp struct {
+		Message string `json:"message"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp.Message
+}
+
+func (pt *paymentTest) eventResult(t *testing.T, txRef, status string) string {
+	t.Helper()
+	var result string
+	err := pt.db.QueryRow(
+		`SELECT COALESCE(result, '') FROM payment_events WHERE tx_ref = $1 AND status = $2`, txRef, status,
+	).Scan(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestWebhookSuccessIssuesTicketsOnce(t *testing.T) {
+	pt := newPaymentTest(t)
+	eventID := testdb.Event(t, pt.db)
+	typeID := testdb.TicketType(t, pt.db, eventID, 150, nil)
+	resp, txRef := pt.purchase(t, eventID, typeID, 2)
+	pt.fake.Pay(txRef)
+
+	for i := 0; i < 2; i++ {
+		if code, msg := pt.deliver(t, txRef, "success", 300); code != http.StatusOK {
+			t.Fatalf("delivery %d answered %d: %s", i+1, code, msg)
+		}
+	}
+	if status := pt.saleStatus(t, resp.ReservationID); status != tickets.StatusCompleted {
+		t.Fatalf("sale is %s, want completed", status)
+	}
+	if n := pt.count(t, `SELECT COUNT(*) FROM tickets WHERE user_id = $1`, pt.userID); n != 2 {
+		t.Fatalf("%d tickets issued, want 2", n)
+	}
+	if result := pt.eventResult(t, txRef, "success"); result != "completed" {
+		t.Fatalf("payment event result = %q, want completed", result)
+	}
+}
+
+func TestWebhookRejectsUnverifiedPayment(t *testing.T) {
+	pt := newPaymentTest(t)
+	eventID := testdb.Event(t, pt.db)
+	typeID := testdb.TicketType(t, pt.db, eventID, 150, nil)
+	resp, txRef := pt.purchase(t, eventID, typeID, 1)
+
+	// The provider still has the payment pending, whatever the body says.
+	if code, msg := pt.deliver(t, txRef, "success", 150); code != http.StatusBadRequest {
+		t.Fatalf("delivery answered %d (%s), want 400", code, msg)
+	}
+	if status := pt.saleStatus(t, resp.ReservationID); status != tickets.StatusPending {
+		t.Fatalf("sale is %s, want pending", status)
+	}
+}
+
+func TestWebhookRejectsBadSignature(t *testing.T) {
+	pt := newPaymentTest(t)
+	body := []byte(`{"event":"charge.success","tx_ref":"tx-1-1","status":"success","amount":1,"currency":"ETB"}`)
+	if w := pt.post(t, "/webhook/chapa", body, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unsigned delivery answered %d, want 401", w.Code)
+	}
+	if n := pt.count(t, `SELECT COUNT(*) FROM payment_webhook_rejections WHERE reason = 'bad signature'`); n != 1 {
+		t.Fatalf("%d rejections recorded, want 1", n)
+	}
+}
+
+func TestWebhookFailureReleasesHold(t *testing.T) {
+	pt := newPaymentTest(t)
+	quantity := 5
+	eventID := testdb.Event(t, pt.db)
+	typeID := testdb.TicketType(t, pt.db, eventID, 150, &quantity)
+	resp, txRef := pt.purchase(t, eventID, typeID, 3)
+	pt.fake.Fail(txRef)
+
+	if code, msg := pt.deliver(t, txRef, "failed", 450); code != http.StatusOK {
+		t.Fatalf("delivery answered %d: %s", code, msg)
+	}
+	if status := pt.saleStatus(t, resp.ReservationID); status != tickets.StatusFailed {
+		t.Fatalf("sale is %s, want failed", status)
+	}
+	if sold := pt.count(t, `SELECT sold FROM ticket_types WHERE id = $1`, typeID); sold != 0 {
+		t.Fatalf("ticket type sold = %d, want the hold released", sold)
+	}
+	if n := pt.count(t, `SELECT COUNT(*) FROM tickets`); n != 0 {
+		t.Fatalf("%d tickets issued, want none", n)
+	}
+	if result := pt.eventResult(t, txRef, "failed"); result != "failed" {
+		t.Fatalf("payment event result = %q, want failed", result)
+	}
+}
+
+func TestWebhookRefundsPaymentAfterHoldExpired(t *testing.T) {
+	pt := newPaymentTest(t)
+	eventID := testdb.Event(t, pt.db)
+	typeID := testdb.TicketType(t, pt.db, eventID, 150, nil)
+	resp, txRef := pt.purchase(t, eventID, typeID, 1)
+
+	if _, err := pt.db.Exec(`UPDATE ticket_sales SET expires_at = NOW() - interval '1 minute' WHERE id = $1`, resp.ReservationID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tickets.ReleaseExpired(pt.db); err != nil {
+		t.Fatal(err)
+	}
+	pt.fake.Pay(txRef)
+
+	if code, msg := pt.deliver(t, txRef, "success", 150); code != http.StatusOK || !strings.Contains(msg, "refunded") {
+		t.Fatalf("delivery answered %d (%s), want 200 and a refund", code, msg)
+	}
+	refunds := pt.fake.Refunds()
+	if len(refunds) != 1 || refunds[0].TxRef != txRef {
+		t.Fatalf("refunds = %+v, want one for %s", refunds, txRef)
+	}
+	if status := pt.saleStatus(t, resp.ReservationID); status != tickets.StatusExpired {
+		t.Fatalf("sale is %s, want expired", status)
+	}
+	if n := pt.count(t, `SELECT COUNT(*) FROM tickets`); n != 0 {
+		t.Fatalf("%d tickets issued, want none", n)
+	}
+	if result := pt.eventResult(t, txRef, "success"); !strings.HasPrefix(result, "refunded") {
+		t.Fatalf("payment event result = %q, want a refund", result)
+	}
+}
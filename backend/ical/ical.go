@@ -0,0 +1,306 @@
+// Package ical writes iCalendar (RFC 5545) calendars of events, for .ics
+// downloads and subscribable feeds. Like package recurrence it has no
+// database or HTTP dependencies.
+//
+// Timed events are written in their own zone with a TZID parameter and a
+// VTIMEZONE generated from the Go zone database, so recurring events keep
+// their local time across daylight-saving changes. All-day events use DATE
+// values.
+package ical
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"local-event-backend/recurrence"
+)
+
+// ProdID identifies this application in every calendar it writes.
+const ProdID = "-//Minab//Local Events//EN"
+
+// Event statuses.
+const (
+	StatusConfirmed = "CONFIRMED"
+	StatusTentative = "TENTATIVE"
+	StatusCancelled = "CANCELLED"
+)
+
+// Geo is a GEO property: latitude and longitude in degrees.
+type Geo struct {
+	Lat float64
+	Lng float64
+}
+
+// Event is one VEVENT. A recurring event has a Rule; an individually edited
+// instance of it is a separate Event with the same UID and a RecurrenceID.
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+	Geo         *Geo
+	Categories  []string
+	Status      string
+
+	Start    time.Time
+	End      time.Time
+	AllDay   bool
+	TimeZone *time.Location
+
+	Rule         *recurrence.Rule
+	ExDates      []time.Time
+	RecurrenceID time.Time
+
+	Created      time.Time
+	LastModified time.Time
+}
+
+// Calendar is a VCALENDAR. Name and RefreshInterval are set on feeds so
+// clients show a title and poll at a sensible rate.
+type Calendar struct {
+	Name            string
+	Description     string
+	RefreshInterval time.Duration
+	Events          []Event
+	// Stamp is the DTSTAMP of every event; zero means now.
+	Stamp time.Time
+}
+
+// Bytes renders c.
+func (c *Calendar) Bytes() []byte {
+	var buf bytes.Buffer
+	c.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+// WriteTo renders c to w with CRLF line endings and lines folded at 75
+// octets.
+func (c *Calendar) WriteTo(w io.Writer) (int64, error) {
+	stamp := c.Stamp
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+
+	l := &lineWriter{}
+	l.line("BEGIN:VCALENDAR")
+	l.line("VERSION:2.0")
+	l.line("PRODID:" + ProdID)
+	l.line("CALSCALE:GREGORIAN")
+	l.line("METHOD:PUBLISH")
+	if c.Name != "" {
+		l.line("NAME:" + escapeText(c.Name))
+		l.line("X-WR-CALNAME:" + escapeText(c.Name))
+	}
+	if c.Description != "" {
+		l.line("DESCRIPTION:" + escapeText(c.Description))
+	}
+	if c.RefreshInterval > 0 {
+		l.line("REFRESH-INTERVAL;VALUE=DURATION:" + formatDuration(c.RefreshInterval))
+		l.line("X-PUBLISHED-TTL:" + formatDuration(c.RefreshInterval))
+	}
+	for _, z := range c.zones() {
+		writeTimeZone(l, z.loc, z.from, z.to)
+	}
+	for i := range c.Events {
+		c.Events[i].write(l, stamp)
+	}
+	l.line("END:VCALENDAR")
+
+	n, err := w.Write(l.buf.Bytes())
+	return int64(n), err
+}
+
+func (e *Event) write(l *lineWriter, stamp time.Time) {
+	l.line("BEGIN:VEVENT")
+	l.line("UID:" + escapeText(e.UID))
+	l.line("DTSTAMP:" + formatUTC(stamp))
+	if !e.RecurrenceID.IsZero() {
+		l.line(e.timeProperty("RECURRENCE-ID", e.RecurrenceID))
+	}
+	l.line(e.timeProperty("DTSTART", e.Start))
+	if !e.End.IsZero() {
+		l.line(e.timeProperty("DTEND", e.End))
+	}
+	if e.Rule != nil {
+		l.line("RRULE:" + e.ruleValue())
+	}
+	for _, ex := range e.ExDates {
+		l.line(e.timeProperty("EXDATE", ex))
+	}
+	l.line("SUMMARY:" + escapeText(e.Summary))
+	if e.Description != "" {
+		l.line("DESCRIPTION:" + escapeText(e.Description))
+	}
+	if e.Location != "" {
+		l.line("LOCATION:" + escapeText(e.Location))
+	}
+	if e.Geo != nil {
+		l.line("GEO:" + formatFloat(e.Geo.Lat) + ";" + formatFloat(e.Geo.Lng))
+	}
+	if len(e.Categories) > 0 {
+		cats := make([]string, len(e.Categories))
+		for i, cat := range e.Categories {
+			cats[i] = escapeText(cat)
+		}
+		l.line("CATEGORIES:" + strings.Join(cats, ","))
+	}
+	if e.Status != "" {
+		l.line("STATUS:" + e.Status)
+	}
+	if e.URL != "" {
+		l.line("URL:" + e.URL)
+	}
+	if !e.Created.IsZero() {
+		l.line("CREATED:" + formatUTC(e.Created))
+	}
+	if !e.LastModified.IsZero() {
+		l.line("LAST-MODIFIED:" + formatUTC(e.LastModified))
+	}
+	l.line("END:VEVENT")
+}
+
+// zone is the event's zone, or UTC when none is set.
+func (e *Event) zone() *time.Location {
+	if e.TimeZone == nil {
+		return time.UTC
+	}
+	return e.TimeZone
+}
+
+// timeProperty writes t as a DATE for all-day events, a UTC DATE-TIME for
+// events in UTC and a local DATE-TIME with TZID otherwise.
+func (e *Event) timeProperty(name string, t time.Time) string {
+	loc := e.zone()
+	switch {
+	case e.AllDay:
+		return name + ";VALUE=DATE:" + t.In(loc).Format("20060102")
+	case loc == time.UTC:
+		return name + ":" + formatUTC(t)
+	default:
+		return name + ";TZID=" + loc.String() + ":" + t.In(loc).Format("20060102T150405")
+	}
+}
+
+// ruleValue is the RRULE value. RFC 5545 wants UNTIL as a DATE when
+// DTSTART is one.
+func (e *Event) ruleValue() string {
+	s := e.Rule.String()
+	if e.AllDay && !e.Rule.Until.IsZero() {
+		utc := "UNTIL=" + formatUTC(e.Rule.Until)
+		s = strings.Replace(s, utc, "UNTIL="+e.Rule.Until.In(e.zone()).Format("20060102"), 1)
+	}
+	return s
+}
+
+type zoneSpan struct {
+	loc      *time.Location
+	from, to time.Time
+}
+
+// zones lists every zone a TZID refers to, with the span of time its
+// VTIMEZONE must cover.
+func (c *Calendar) zones() []zoneSpan {
+	var spans []zoneSpan
+	index := map[string]int{}
+	for _, e := range c.Events {
+		loc := e.zone()
+		if e.AllDay || loc == time.UTC {
+			continue
+		}
+		from, to := e.Start, e.End
+		if !e.RecurrenceID.IsZero() && e.RecurrenceID.Before(from) {
+			from = e.RecurrenceID
+		}
+		if to.Before(from) {
+			to = from
+		}
+		if e.Rule != nil {
+			// Open-ended series are expanded by clients indefinitely; cover
+			// a few years and let the last observance carry on from there.
+			to = from.AddDate(5, 0, 0)
+			if !e.Rule.Until.IsZero() && e.Rule.Until.Before(to) {
+				to = e.Rule.Until
+			}
+		}
+		i, ok := index[loc.String()]
+		if !ok {
+			index[loc.String()] = len(spans)
+			spans = append(spans, zoneSpan{loc: loc, from: from, to: to})
+			continue
+		}
+		if from.Before(spans[i].from) {
+			spans[i].from = from
+		}
+		if to.After(spans[i].to) {
+			spans[i].to = to
+		}
+	}
+	return spans
+}
+
+// escapeText escapes a TEXT value.
+func escapeText(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+func formatUTC(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// formatDuration writes d as an RFC 5545 DURATION such as PT1H or P1D.
+func formatDuration(d time.Duration) string {
+	secs := int64(d / time.Second)
+	days := secs / 86400
+	secs %= 86400
+	out := "P"
+	if days > 0 {
+		out += strconv.FormatInt(days, 10) + "D"
+	}
+	if secs > 0 || days == 0 {
+		out += "T"
+		if h := secs / 3600; h > 0 {
+			out += strconv.FormatInt(h, 10) + "H"
+		}
+		if m := secs % 3600 / 60; m > 0 {
+			out += strconv.FormatInt(m, 10) + "M"
+		}
+		if s := secs % 60; s > 0 || secs == 0 {
+			out += strconv.FormatInt(s, 10) + "S"
+		}
+	}
+	return out
+}
+
+// lineWriter accumulates content lines, folding each at 75 octets without
+// splitting a UTF-8 sequence.
+type lineWriter struct {
+	buf bytes.Buffer
+}
+
+func (l *lineWriter) line(s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		l.buf.WriteString(s[:cut])
+		l.buf.WriteString("\r\n ")
+		s = s[cut:]
+		// Continuation lines start with the folding space.
+		limit = 74
+	}
+	l.buf.WriteString(s)
+	l.buf.WriteString("\r\n")
+}
@@ -0,0 +1,95 @@
+package ical
+
+import (
+	"fmt"
+	"time"
+)
+
+// offsetAt is loc's UTC offset, abbreviation and DST flag at t.
+func offsetAt(loc *time.Location, t time.Time) (int, string, bool) {
+	t = t.In(loc)
+	name, offset := t.Zone()
+	return offset, name, t.IsDST()
+}
+
+// transitions finds the instants in (from, to] at which loc's offset
+// changes. Zones change at most a few times a year, so a daily scan refined
+// by bisection finds each change to the second.
+func transitions(loc *time.Location, from, to time.Time) []time.Time {
+	var out []time.Time
+	prev := from
+	prevOffset, _, _ := offsetAt(loc, prev)
+	for t := from.Add(24 * time.Hour); ; t = t.Add(24 * time.Hour) {
+		if t.After(to) {
+			t = to
+		}
+		offset, _, _ := offsetAt(loc, t)
+		if offset != prevOffset {
+			lo, hi := prev, t
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2).Truncate(time.Second)
+				if o, _, _ := offsetAt(loc, mid); o == prevOffset {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			out = append(out, hi)
+			prevOffset = offset
+		}
+		if !t.Before(to) {
+			return out
+		}
+		prev = t
+	}
+}
+
+// writeTimeZone writes a VTIMEZONE for loc covering from to to: one
+// observance for the offset in effect at from, then one per change.
+func writeTimeZone(l *lineWriter, loc *time.Location, from, to time.Time) {
+	// Start on a whole day before the first event so its DTSTART is
+	// always covered.
+	start := from.In(loc).Add(-24 * time.Hour).Truncate(time.Hour)
+	offset, name, dst := offsetAt(loc, start)
+
+	l.line("BEGIN:VTIMEZONE")
+	l.line("TZID:" + loc.String())
+	writeObservance(l, dst, start.In(loc), offset, offset, name)
+	for _, t := range transitions(loc, start, to) {
+		next, nextName, nextDST := offsetAt(loc, t)
+		// DTSTART is the onset in the local time in effect before it.
+		onset := t.In(time.FixedZone("", offset))
+		writeObservance(l, nextDST, onset, offset, next, nextName)
+		offset = next
+	}
+	l.line("END:VTIMEZONE")
+}
+
+func writeObservance(l *lineWriter, dst bool, onset time.Time, from, to int, name string) {
+	kind := "STANDARD"
+	if dst {
+		kind = "DAYLIGHT"
+	}
+	l.line("BEGIN:" + kind)
+	l.line("DTSTART:" + onset.Format("20060102T150405"))
+	l.line("TZOFFSETFROM:" + formatOffset(from))
+	l.line("TZOFFSETTO:" + formatOffset(to))
+	if name != "" {
+		l.line("TZNAME:" + escapeText(name))
+	}
+	l.line("END:" + kind)
+}
+
+// formatOffset writes a UTC-OFFSET such as +0300 or -0930.
+func formatOffset(secs int) string {
+	sign := "+"
+	if secs < 0 {
+		sign = "-"
+		secs = -secs
+	}
+	s := fmt.Sprintf("%s%02d%02d", sign, secs/3600, secs%3600/60)
+	if secs%60 != 0 {
+		s += fmt.Sprintf("%02d", secs%60)
+	}
+	return s
+}
@@ -117,3 +117,425 @@ CREATE INDEX IF NOT EXISTS idx_events_search_mv_tags ON events_search_mv USING g
 ALTER TABLE ticket_sales ADD COLUMN IF NOT EXISTS tx_ref text;
 ALTER TABLE ticket_sales ADD COLUMN IF NOT EXISTS checkout_url text;
 
+-- Refresh-token sessions. Only a SHA-256 hash of each refresh token is
+-- stored; every rotation inserts a new row in the same family so reuse of an
+-- old token can revoke the whole chain.
+CREATE TABLE IF NOT EXISTS user_sessions (
+  id serial PRIMARY KEY,
+  user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  family_id uuid NOT NULL,
+  refresh_token_hash text UNIQUE NOT NULL,
+  remember_me boolean NOT NULL DEFAULT false,
+  user_agent text,
+  ip_address text,
+  expires_at timestamptz NOT NULL,
+  revoked_at timestamptz,
+  replaced_by integer REFERENCES user_sessions(id),
+  created_at timestamptz DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user ON user_sessions (user_id);
+CREATE INDEX IF NOT EXISTS idx_user_sessions_family ON user_sessions (family_id);
+
+-- Hasura roles per user, issued as x-hasura-allowed-roles.
+CREATE TABLE IF NOT EXISTS user_roles (
+  user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  role text NOT NULL CHECK (role IN ('attendee', 'organizer', 'admin')),
+  created_at timestamptz DEFAULT now(),
+  PRIMARY KEY (user_id, role)
+);
+
+-- Backfill: every existing user attends, and anyone who already owns an
+-- event keeps being able to create them.
+INSERT INTO user_roles (user_id, role)
+SELECT id, 'attendee' FROM users
+ON CONFLICT DO NOTHING;
+
+INSERT INTO user_roles (user_id, role)
+SELECT DISTINCT e.user_id, 'organizer' FROM events e JOIN users u ON u.id = e.user_id
+ON CONFLICT DO NOTHING;
+
+-- Set once the user opens the link from their verification email.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at timestamptz;
+
+-- Single-use password reset tokens; only a SHA-256 hash is stored.
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+  id serial PRIMARY KEY,
+  user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  token_hash text UNIQUE NOT NULL,
+  expires_at timestamptz NOT NULL,
+  used_at timestamptz,
+  created_at timestamptz DEFAULT now()
+);
+
+-- Failed-login counters shared by all backend instances. Keys look like
+-- "email:<address>" or "ip:<address>".
+CREATE TABLE IF NOT EXISTS login_throttle (
+  key text PRIMARY KEY,
+  failures integer NOT NULL DEFAULT 0,
+  last_failure_at timestamptz NOT NULL
+);
+
+-- Audit trail of every login attempt.
+CREATE TABLE IF NOT EXISTS login_attempts (
+  id bigserial PRIMARY KEY,
+  email text NOT NULL,
+  user_id integer REFERENCES users(id) ON DELETE SET NULL,
+  ip_address text,
+  user_agent text,
+  success boolean NOT NULL,
+  reason text,
+  created_at timestamptz DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_email ON login_attempts (email, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts (ip_address, created_at);
+
+-- Emails are stored lowercased and unique regardless of case.
+--
+-- Older databases can hold addresses that differ only in case or spacing.
+-- Before normalizing, each such group is resolved: the oldest account (the
+-- lowest id) keeps the address and the others are renamed to
+-- duplicate-<id>@duplicate.invalid, so they can no longer sign in. Their
+-- original addresses are kept in user_email_duplicates for an admin to merge
+-- or restore by hand.
+CREATE TABLE IF NOT EXISTS user_email_duplicates (
+  user_id integer PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  original_email text NOT NULL,
+  kept_user_id integer REFERENCES users(id) ON DELETE SET NULL,
+  created_at timestamptz DEFAULT now()
+);
+INSERT INTO user_email_duplicates (user_id, original_email, kept_user_id)
+SELECT u.id, u.email, k.id
+FROM users u
+JOIN (SELECT lower(trim(email)) AS norm, MIN(id) AS id FROM users GROUP BY lower(trim(email))) k
+  ON k.norm = lower(trim(u.email))
+WHERE u.id <> k.id
+ON CONFLICT (user_id) DO NOTHING;
+UPDATE users SET email = 'duplicate-' || id || '@duplicate.invalid'
+WHERE id IN (SELECT user_id FROM user_email_duplicates)
+  AND email <> 'duplicate-' || id || '@duplicate.invalid';
+UPDATE users SET email = lower(trim(email)) WHERE email <> lower(trim(email));
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_key ON users (lower(email));
+
+-- Accounts at external OpenID Connect providers linked to local users.
+CREATE TABLE IF NOT EXISTS user_identities (
+  provider text NOT NULL,
+  subject text NOT NULL,
+  user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  email text,
+  created_at timestamptz DEFAULT now(),
+  PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities (user_id);
+
+-- TOTP two-factor authentication. pending_secret holds a secret being set
+-- up until the user confirms it with a code; last_used_step stops a code
+-- from being used twice.
+CREATE TABLE IF NOT EXISTS user_mfa (
+  user_id integer PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  totp_secret text,
+  pending_secret text,
+  enabled_at timestamptz,
+  last_used_step bigint,
+  created_at timestamptz DEFAULT now()
+);
+
+-- One-time backup codes; only a SHA-256 hash is stored.
+CREATE TABLE IF NOT EXISTS user_backup_codes (
+  id serial PRIMARY KEY,
+  user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  code_hash text NOT NULL,
+  used_at timestamptz,
+  created_at timestamptz DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_backup_codes_user ON user_backup_codes (user_id);
+
+-- SignupHandler stores the number given at signup here, normalized to E.164;
+-- phone OTP login looks users up by it.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone_number text;
+
+-- One-time login codes sent by SMS; only a hash of phone and code is stored.
+CREATE TABLE IF NOT EXISTS phone_otps (
+  id serial PRIMARY KEY,
+  phone text NOT NULL,
+  code_hash text NOT NULL,
+  attempts integer NOT NULL DEFAULT 0,
+  expires_at timestamptz NOT NULL,
+  consumed_at timestamptz,
+  created_at timestamptz DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_phone_otps_phone ON phone_otps (phone, created_at);
+
+-- phone_number is the canonical phone column; copy numbers stored in the
+-- legacy 'phone' column so OTP login and /me see them. 'phone' is kept for
+-- older clients but no longer written.
+UPDATE users SET phone_number = phone WHERE phone_number IS NULL AND phone IS NOT NULL AND phone <> '';
+CREATE UNIQUE INDEX IF NOT EXISTS users_phone_number_key ON users (phone_number) WHERE phone_number IS NOT NULL;
+
+-- Profile fields edited through /me. pending_email holds a requested new
+-- address until its verification link is opened. deleted_at marks accounts
+-- anonymized by /delete-account; their rows stay so bookmarks, follows and
+-- tickets keep valid foreign keys.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_url text;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS preferred_language text NOT NULL DEFAULT 'en';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS notification_preferences jsonb NOT NULL DEFAULT '{"email": true, "sms": false, "event_updates": true, "marketing": false}';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email text;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+
+-- Organizations let a team of staff run events together. Each member holds
+-- one role: owner (everything, including the team), manager (events) or
+-- door-staff (ticket check-in only).
+CREATE TABLE IF NOT EXISTS organizations (
+  id serial PRIMARY KEY,
+  name text NOT NULL,
+  slug text UNIQUE NOT NULL,
+  description text,
+  logo_url text,
+  website text,
+  created_by integer REFERENCES users(id) ON DELETE SET NULL,
+  created_at timestamptz DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS organization_members (
+  organization_id integer NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  role text NOT NULL CHECK (role IN ('owner', 'manager', 'door-staff')),
+  created_at timestamptz DEFAULT now(),
+  PRIMARY KEY (organization_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_organization_members_user ON organization_members (user_id);
+
+-- Events owned by an organization are managed by its members; user_id still
+-- records who created the event.
+ALTER TABLE events ADD COLUMN IF NOT EXISTS organization_id integer REFERENCES organizations(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_events_organization ON events (organization_id);
+
+-- Cancelling an event keeps the row, and with it every ticket and sale.
+ALTER TABLE events ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'published';
+ALTER TABLE events ADD COLUMN IF NOT EXISTS cancelled_at timestamptz;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS cancellation_reason text;
+
+-- Categories are managed through Hasura; events reference them by id and
+-- CreateEventHandler checks the id exists.
+CREATE TABLE IF NOT EXISTS categories (
+  id serial PRIMARY KEY,
+  name text UNIQUE NOT NULL,
+  image text
+);
+
+-- Event lifecycle, enforced by the events package. Drafts with publish_at
+-- are published by the backend scheduler when that time arrives.
+ALTER TABLE events ADD COLUMN IF NOT EXISTS publish_at timestamptz;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS published_at timestamptz;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS postponed_at timestamptz;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS postponement_reason text;
+ALTER TABLE events DROP CONSTRAINT IF EXISTS events_status_check;
+ALTER TABLE events ADD CONSTRAINT events_status_check
+  CHECK (status IN ('draft', 'published', 'postponed', 'cancelled', 'completed'));
+CREATE INDEX IF NOT EXISTS idx_events_publish_at ON events (publish_at) WHERE status = 'draft';
+
+-- Start and end instants plus the IANA zone the event takes place in.
+-- event_date is kept as the local start date for older clients. Rows from
+-- before this change become all-day events in Addis Ababa time.
+ALTER TABLE events ADD COLUMN IF NOT EXISTS starts_at timestamptz;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS ends_at timestamptz;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS doors_open_at timestamptz;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS time_zone text NOT NULL DEFAULT 'Africa/Addis_Ababa';
+UPDATE events
+SET starts_at = (COALESCE(event_date, created_at::date)::timestamp AT TIME ZONE time_zone),
+    ends_at = ((COALESCE(event_date, created_at::date) + 1)::timestamp AT TIME ZONE time_zone)
+WHERE starts_at IS NULL;
+ALTER TABLE events DROP CONSTRAINT IF EXISTS events_schedule_check;
+ALTER TABLE events ADD CONSTRAINT events_schedule_check
+  CHECK (ends_at > starts_at AND (doors_open_at IS NULL OR doors_open_at <= starts_at));
+CREATE INDEX IF NOT EXISTS idx_events_starts_at ON events (starts_at);
+
+-- Computed fields: an event's times as wall-clock times in its own zone,
+-- so clients render them without converting.
+CREATE OR REPLACE FUNCTION public.event_local_starts_at(e events)
+RETURNS timestamp
+LANGUAGE sql
+STABLE
+AS $$
+  SELECT e.starts_at AT TIME ZONE e.time_zone;
+$$;
+
+CREATE OR REPLACE FUNCTION public.event_local_ends_at(e events)
+RETURNS timestamp
+LANGUAGE sql
+STABLE
+AS $$
+  SELECT e.ends_at AT TIME ZONE e.time_zone;
+$$;
+
+CREATE OR REPLACE FUNCTION public.event_local_doors_open_at(e events)
+RETURNS timestamp
+LANGUAGE sql
+STABLE
+AS $$
+  SELECT e.doors_open_at AT TIME ZONE e.time_zone;
+$$;
+
+-- Recurring events. rrule is an RFC 5545 RRULE whose first instance is
+-- starts_at; exdates are instances left out. The backend expands the rule
+-- into event_occurrences a year ahead, and each occurrence has its own
+-- ticket inventory. Rows marked modified were edited individually and are
+-- left alone when the series is regenerated. recurrence_parent_id links a
+-- series split off by a "this and following" edit to the original.
+ALTER TABLE events ADD COLUMN IF NOT EXISTS rrule text;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS exdates timestamptz[] NOT NULL DEFAULT '{}';
+ALTER TABLE events ADD COLUMN IF NOT EXISTS occurrence_capacity integer CHECK (occurrence_capacity >= 0);
+ALTER TABLE events ADD COLUMN IF NOT EXISTS recurrence_parent_id integer REFERENCES events(id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS event_occurrences (
+  id serial PRIMARY KEY,
+  event_id integer NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+  original_starts_at timestamptz NOT NULL,
+  starts_at timestamptz NOT NULL,
+  ends_at timestamptz NOT NULL,
+  doors_open_at timestamptz,
+  status text NOT NULL DEFAULT 'scheduled' CHECK (status IN ('scheduled', 'cancelled')),
+  capacity integer CHECK (capacity >= 0),
+  tickets_sold integer NOT NULL DEFAULT 0 CHECK (tickets_sold >= 0),
+  modified boolean NOT NULL DEFAULT false,
+  created_at timestamptz DEFAULT now(),
+  UNIQUE (event_id, original_starts_at),
+  CHECK (ends_at > starts_at),
+  CHECK (capacity IS NULL OR tickets_sold <= capacity)
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_occurrences_starts_at ON event_occurrences (event_id, starts_at);
+
+ALTER TABLE ticket_sales ADD COLUMN IF NOT EXISTS occurrence_id integer REFERENCES event_occurrences(id) ON DELETE SET NULL;
+ALTER TABLE tickets ADD COLUMN IF NOT EXISTS occurrence_id integer REFERENCES event_occurrences(id) ON DELETE SET NULL;
+
+-- Personal calendar feed tokens, one per user; only a SHA-256 hash is
+-- stored. Issuing a new token replaces the old one.
+CREATE TABLE IF NOT EXISTS calendar_feed_tokens (
+  user_id integer PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  token_hash text UNIQUE NOT NULL,
+  created_at timestamptz DEFAULT now(),
+  last_used_at timestamptz
+);
+
+-- Ticket types: the kinds of ticket an event sells, each with its own
+-- price, currency, stock (NULL is unlimited), per-order limits and sale
+-- window. The server prices every order from these rows.
+CREATE TABLE IF NOT EXISTS ticket_types (
+  id serial PRIMARY KEY,
+  event_id integer NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+  name text NOT NULL,
+  description text,
+  price numeric(12,2) NOT NULL CHECK (price >= 0),
+  currency text NOT NULL DEFAULT 'ETB' CHECK (currency IN ('ETB', 'USD')),
+  quantity integer CHECK (quantity >= 0),
+  min_per_order integer NOT NULL DEFAULT 1 CHECK (min_per_order >= 1),
+  max_per_order integer NOT NULL DEFAULT 10,
+  sales_start_at timestamptz,
+  sales_end_at timestamptz,
+  sort_order integer NOT NULL DEFAULT 0,
+  created_at timestamptz DEFAULT now(),
+  CHECK (max_per_order >= min_per_order),
+  CHECK (sales_end_at IS NULL OR sales_start_at IS NULL OR sales_end_at > sales_start_at)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS ticket_types_event_name_key ON ticket_types (event_id, lower(name));
+
+-- Backfill: events from before ticket types sell one General type at
+-- their old price.
+INSERT INTO ticket_types (event_id, name, price)
+SELECT e.id, 'General', COALESCE(e.price, 0) FROM events e
+WHERE NOT EXISTS (SELECT 1 FROM ticket_types t WHERE t.event_id = e.id);
+
+ALTER TABLE ticket_sales ADD COLUMN IF NOT EXISTS ticket_type_id integer REFERENCES ticket_types(id) ON DELETE RESTRICT;
+ALTER TABLE ticket_sales ADD COLUMN IF NOT EXISTS unit_price numeric(12,2);
+ALTER TABLE ticket_sales ADD COLUMN IF NOT EXISTS currency text;
+ALTER TABLE tickets ADD COLUMN IF NOT EXISTS ticket_type_id integer REFERENCES ticket_types(id) ON DELETE RESTRICT;
+
+-- Reservations: ticket_types.sold counts tickets sold or held by a pending
+-- order and is only ever raised by a conditional UPDATE, so it can never
+-- pass quantity. Pending orders hold their seats until expires_at, after
+-- which the backend's reaper marks them expired and returns the seats.
+ALTER TABLE ticket_sales ADD COLUMN IF NOT EXISTS expires_at timestamptz;
+UPDATE ticket_sales SET expires_at = created_at + interval '15 minutes'
+WHERE status = 'pending' AND expires_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_ticket_sales_expires_at ON ticket_sales (expires_at) WHERE status = 'pending';
+
+ALTER TABLE ticket_types ADD COLUMN IF NOT EXISTS sold integer NOT NULL DEFAULT 0;
+UPDATE ticket_types t SET sold = s.n
+FROM (
+  SELECT ticket_type_id, SUM(quantity) AS n FROM ticket_sales
+  WHERE status IN ('pending', 'completed') AND ticket_type_id IS NOT NULL
+  GROUP BY ticket_type_id
+) s
+WHERE t.id = s.ticket_type_id AND t.sold = 0;
+ALTER TABLE ticket_types DROP CONSTRAINT IF EXISTS ticket_types_sold_check;
+ALTER TABLE ticket_types ADD CONSTRAINT ticket_types_sold_check
+  CHECK (sold >= 0 AND (quantity IS NULL OR sold <= quantity));
+
+-- Which payment provider a sale was paid through.
+ALTER TABLE ticket_sales ADD COLUMN IF NOT EXISTS payment_provider text;
+
+-- Payment webhooks that were refused: bad signatures, unknown references,
+-- or transactions the provider does not confirm.
+CREATE TABLE IF NOT EXISTS payment_webhook_rejections (
+  id bigserial PRIMARY KEY,
+  provider text NOT NULL,
+  tx_ref text,
+  reason text NOT NULL,
+  ip_address text,
+  signature text,
+  payload text,
+  created_at timestamptz DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_webhook_rejections_created ON payment_webhook_rejections (created_at);
+
+-- Ledger of every payment webhook delivery. A provider's reference and
+-- event type identify a delivery, so retries land on the same row;
+-- processed_at is set once it has been acted on.
+CREATE TABLE IF NOT EXISTS payment_events (
+  id bigserial PRIMARY KEY,
+  provider text NOT NULL,
+  reference text NOT NULL,
+  event_type text NOT NULL,
+  tx_ref text,
+  status text,
+  amount numeric(12,2),
+  currency text,
+  payload text,
+  attempts integer NOT NULL DEFAULT 1,
+  sale_id integer REFERENCES ticket_sales(id) ON DELETE SET NULL,
+  result text,
+  received_at timestamptz DEFAULT now(),
+  processed_at timestamptz,
+  CONSTRAINT payment_events_reference_key UNIQUE (provider, reference, event_type)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_events_tx_ref ON payment_events (tx_ref);
+
+-- The provider's reference for a completed sale's payment.
+ALTER TABLE ticket_sales ADD COLUMN IF NOT EXISTS payment_reference text;
+CREATE INDEX IF NOT EXISTS idx_ticket_sales_tx_ref ON ticket_sales (tx_ref);
+
+-- Whether the user chose a password. Accounts created through a social
+-- login get an unguessable one and re-authenticate another way. Existing
+-- accounts created that way are recognised by an identity linked at the
+-- moment the user row was created.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS has_password boolean;
+UPDATE users u SET has_password = NOT EXISTS (
+  SELECT 1 FROM user_identities i
+  WHERE i.user_id = u.id AND i.created_at BETWEEN u.created_at - interval '5 seconds' AND u.created_at + interval '5 seconds'
+)
+WHERE has_password IS NULL;
+ALTER TABLE users ALTER COLUMN has_password SET DEFAULT true;
+ALTER TABLE users ALTER COLUMN has_password SET NOT NULL;
+
+-- When the attempt before the latest one was made. Attempts are counted
+-- before the password is checked, and the throttle decides from the counter
+-- as it stood just before.
+ALTER TABLE login_throttle ADD COLUMN IF NOT EXISTS previous_failure_at timestamptz;
@@ -0,0 +1,60 @@
+// Package mailer sends transactional email (verification links, password
+// resets). The implementation is picked from configuration so development
+// and tests never need a mail server.
+package mailer
+
+import (
+	"fmt"
+	"os"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers a Message.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Default is the mailer handlers send through. main replaces it with the one
+// returned by FromEnv.
+var Default Mailer = LogMailer{}
+
+// FromEnv builds a Mailer from MAILER:
+//
+//	smtp  SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD, MAIL_FROM
+//	file  appends every message to MAIL_FILE
+//	log   (default) prints messages to the server log
+func FromEnv() (Mailer, error) {
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		m := SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+		if m.Host == "" || m.From == "" {
+			return nil, fmt.Errorf("SMTP_HOST and MAIL_FROM are required for MAILER=smtp")
+		}
+		if m.Port == "" {
+			m.Port = "587"
+		}
+		return m, nil
+	case "file":
+		path := os.Getenv("MAIL_FILE")
+		if path == "" {
+			path = "./mail.log"
+		}
+		return &FileMailer{Path: path}, nil
+	case "", "log":
+		return LogMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q", os.Getenv("MAILER"))
+	}
+}
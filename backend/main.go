@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
-	
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 
+	"local-event-backend/auth"
+	"local-event-backend/events"
 	"local-event-backend/handlers"
+	"local-event-backend/mailer"
+	"local-event-backend/payments"
+	"local-event-backend/sms"
+	"local-event-backend/tickets"
 	"local-event-backend/utils"
 )
 
@@ -24,20 +31,62 @@ func main() {
 		log.Fatalf("❌ Failed to connect to database: %v", err)
 	}
 
-	// 3. Initialize Uploads Folder
+	// 3. Load JWT signing keys
+	if _, err := utils.LoadKeys(); err != nil {
+		log.Fatalf("❌ Failed to load JWT signing keys: %v", err)
+	}
+
+	// 4. Configure outgoing mail
+	m, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatalf("❌ Failed to configure mailer: %v", err)
+	}
+	mailer.Default = m
+
+	// Outgoing SMS
+	smsSender, err := sms.FromEnv()
+	if err != nil {
+		log.Fatalf("❌ Failed to configure SMS sender: %v", err)
+	}
+	sms.Default = smsSender
+
+	// Payment provider
+	provider, err := payments.FromEnv()
+	if err != nil {
+		log.Fatalf("❌ Failed to configure payment provider: %v", err)
+	}
+	payments.Default = provider
+
+	// Social login providers
+	if err := auth.LoadOIDCProviders(); err != nil {
+		log.Fatalf("❌ Failed to configure OIDC providers: %v", err)
+	}
+
+	// Share login throttling counters between instances unless told otherwise
+	if os.Getenv("LOGIN_THROTTLE_STORE") != "memory" {
+		auth.Logins = auth.NewLoginGuard(auth.PostgresAttemptStore{DB: utils.DB})
+	}
+
+	// Publish scheduled drafts and complete past events in the background
+	go events.RunScheduler(context.Background(), time.Minute)
+
+	// Put seats from abandoned checkouts back on sale
+	go tickets.RunReaper(context.Background(), 30*time.Second)
+
+	// 5. Initialize Uploads Folder
 	utils.InitUploadPath() 
 
-	// 4. Create Gin
+	// 6. Create Gin
 	r := gin.New()
 	r.Use(gin.Logger())
 	r.Use(gin.Recovery())
 
-	// 5. Improved CORS Middleware for Hasura & Nuxt
+	// 7. Improved CORS Middleware for Hasura & Nuxt
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*") // Allow Hasura Docker
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, x-hasura-admin-secret")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, x-hasura-admin-secret, x-hasura-role")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -46,24 +95,94 @@ func main() {
 		c.Next()
 	})
 
-	// 6. Serve Uploaded Images
+	// 8. Serve Uploaded Images
 	r.Static("/uploads", "./uploads") 
 
-	// 7. Register Routes
+	// 9. Register Routes
+	r.GET("/.well-known/jwks.json", handlers.JWKSHandler)
 	r.POST("/login", handlers.LoginHandler)
+	r.POST("/login/mfa", handlers.LoginMFAHandler)
+	r.POST("/request-otp", handlers.RequestOTPHandler)
+	r.POST("/verify-otp", handlers.VerifyOTPHandler)
 	r.POST("/signup", handlers.SignupHandler)
+	r.POST("/refresh", handlers.RefreshTokenHandler)
+	r.POST("/logout", handlers.LogoutHandler)
+	r.POST("/verify-email", handlers.VerifyEmailHandler)
+	r.POST("/forgot-password", handlers.ForgotPasswordHandler)
+	r.POST("/reset-password", handlers.ResetPasswordHandler)
+	r.GET("/auth/oidc/start", handlers.OIDCStartHandler)
+	r.GET("/auth/oidc/callback", handlers.OIDCCallbackHandler)
 	r.POST("/upload", handlers.UploadHandler)
-	r.POST("/create-event", handlers.CreateEventHandler)
+
+	// Hasura actions that act on behalf of the logged-in user. Hasura either
+	// sends the action secret with session variables or forwards the client's
+	// Authorization header.
+	actions := r.Group("/", handlers.AuthMiddleware())
+	actions.POST("/logout-all", handlers.LogoutAllHandler)
+	actions.POST("/resend-verification", handlers.ResendVerificationHandler)
+	actions.POST("/change-password", handlers.ChangePasswordHandler)
+	actions.POST("/mfa/enroll", handlers.MFAEnrollHandler)
+	actions.POST("/mfa/activate", handlers.MFAActivateHandler)
+	actions.POST("/mfa/disable", handlers.MFADisableHandler)
+	actions.POST("/me", handlers.GetProfileHandler)
+	actions.POST("/update-me", handlers.UpdateProfileHandler)
+	actions.POST("/delete-account", handlers.DeleteAccountHandler)
+	actions.POST("/calendar-feed", handlers.CalendarFeedHandler)
+	actions.POST("/create-event", handlers.RequireRole(auth.RoleOrganizer), handlers.RequireVerifiedEmail(), handlers.CreateEventHandler)
+	actions.POST("/update-event", handlers.RequireRole(auth.RoleOrganizer), handlers.UpdateEventHandler)
+	actions.POST("/update-occurrence", handlers.RequireRole(auth.RoleOrganizer), handlers.UpdateOccurrenceHandler)
+	actions.POST("/create-ticket-type", handlers.RequireRole(auth.RoleOrganizer), handlers.CreateTicketTypeHandler)
+	actions.POST("/update-ticket-type", handlers.RequireRole(auth.RoleOrganizer), handlers.UpdateTicketTypeHandler)
+	actions.POST("/delete-ticket-type", handlers.RequireRole(auth.RoleOrganizer), handlers.DeleteTicketTypeHandler)
+	actions.POST("/publish-event", handlers.RequireRole(auth.RoleOrganizer), handlers.RequireVerifiedEmail(), handlers.PublishEventHandler)
+	actions.POST("/postpone-event", handlers.RequireRole(auth.RoleOrganizer), handlers.PostponeEventHandler)
+	actions.POST("/cancel-event", handlers.RequireRole(auth.RoleOrganizer), handlers.CancelEventHandler)
+	actions.POST("/delete-event", handlers.RequireRole(auth.RoleOrganizer), handlers.DeleteEventHandler)
+	actions.POST("/create-organization", handlers.RequireRole(auth.RoleOrganizer), handlers.CreateOrganizationHandler)
+	actions.POST("/my-organizations", handlers.MyOrganizationsHandler)
+	actions.POST("/add-organization-member", handlers.AddOrganizationMemberHandler)
+	actions.POST("/update-organization-member", handlers.UpdateOrganizationMemberHandler)
+	actions.POST("/remove-organization-member", handlers.RemoveOrganizationMemberHandler)
+	actions.POST("/bookmark-event", handlers.CreateBookmarkHandler)
+	actions.POST("/unbookmark-event", handlers.DeleteBookmarkHandler)
+	actions.POST("/follow-event", handlers.FollowEventHandler)
+	actions.POST("/unfollow-event", handlers.UnfollowEventHandler)
+	actions.POST("/purchase-ticket", handlers.RequireVerifiedEmail(), handlers.PurchaseTicketHandler)
+	actions.POST("/initialize-payment", handlers.RequireVerifiedEmail(), handlers.InitializePaymentHandler)
+
+	// Moderation actions, only for callers running as admin.
+	admin := actions.Group("/", handlers.RequireRole(auth.RoleAdmin))
+	admin.POST("/create-user", handlers.CreateUserHandler)
+	admin.POST("/grant-role", handlers.GrantRoleHandler)
+	admin.POST("/revoke-role", handlers.RevokeRoleHandler)
+
+	// Same handlers as plain REST endpoints for direct calls from the frontend.
+	api := r.Group("/api", handlers.AuthMiddleware())
+	api.POST("/users", handlers.RequireRole(auth.RoleAdmin), handlers.CreateUserHandler)
+	api.GET("/me", handlers.GetProfileHandler)
+	api.PATCH("/me", handlers.UpdateProfileHandler)
+	api.DELETE("/me", handlers.DeleteAccountHandler)
+	api.POST("/bookmarks", handlers.CreateBookmarkHandler)
+	api.DELETE("/bookmarks", handlers.DeleteBookmarkHandler)
+	api.POST("/follows", handlers.FollowEventHandler)
+	api.DELETE("/follows", handlers.UnfollowEventHandler)
+	api.POST("/tickets/purchase", handlers.RequireVerifiedEmail(), handlers.PurchaseTicketHandler)
 	
 	// CHAPA ROUTES
-	r.POST("/initialize-payment", handlers.InitializePaymentHandler) 
 	r.POST("/webhook/chapa", handlers.ChapaWebhookHandler)
 
+	// iCalendar downloads and feeds; calendar apps cannot send our tokens,
+	// so personal feeds carry their own in the URL.
+	r.GET("/events/:file", handlers.EventCalendarHandler)
+	r.GET("/calendars/users/:file", handlers.UserCalendarHandler)
+	r.GET("/calendars/organizers/:file", handlers.OrganizerCalendarHandler)
+	r.GET("/calendars/organizations/:file", handlers.OrganizationCalendarHandler)
+
 	r.GET("/healthz", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
-	// 8. Start Server on a STABLE port for Hasura
+	// 10. Start Server on a STABLE port for Hasura
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8082" // Keep it 8082 so Hasura always knows where to find it
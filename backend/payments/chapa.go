@@ -0,0 +1,232 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ChapaBaseURL is Chapa's production API.
+const ChapaBaseURL = "https://api.chapa.co/v1"
+
+// Chapa takes payments through Chapa (https://developer.chapa.co). BaseURL
+// can point at a mock server in tests. WebhookSecret is the secret set on
+// the Chapa dashboard; without it every webhook is rejected.
+type Chapa struct {
+	BaseURL       string
+	SecretKey     string
+	WebhookSecret string
+	Client        *http.Client
+}
+
+func (*Chapa) Name() string { return "chapa" }
+
+// chapaResponse is the envelope every Chapa endpoint answers with. message
+// is usually a string but can be an object of field errors.
+type chapaResponse struct {
+	Status  string          `json:"status"`
+	Message json.RawMessage `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func (r chapaResponse) message() string {
+	var s string
+	if json.Unmarshal(r.Message, &s) == nil {
+		return s
+	}
+	return string(r.Message)
+}
+
+// chapaAmount reads amounts, which Chapa sends as numbers or strings.
+type chapaAmount float64
+
+func (a *chapaAmount) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*a = 0
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("chapa: bad amount %s", b)
+	}
+	*a = chapaAmount(f)
+	return nil
+}
+
+// chapaTransaction is the transaction shape of verify responses and
+// webhook bodies.
+type chapaTransaction struct {
+	Event     string      `json:"event"`
+	TxRef     string      `json:"tx_ref"`
+	Reference string      `json:"reference"`
+	Status    string      `json:"status"`
+	Amount    chapaAmount `json:"amount"`
+	Currency  string      `json:"currency"`
+}
+
+func chapaStatus(s string) Status {
+	switch strings.ToLower(s) {
+	case "success", "successful":
+		return StatusSuccess
+	case "failed", "failure", "cancelled":
+		return StatusFailed
+	case "refunded", "reversed":
+		return StatusRefunded
+	default:
+		return StatusPending
+	}
+}
+
+// call sends a request to path and decodes the envelope. A non-2xx answer
+// or a status other than "success" is an error carrying Chapa's message.
+func (p *Chapa) call(ctx context.Context, method, path string, body interface{}) (chapaResponse, int, error) {
+	var resp chapaResponse
+	if p.SecretKey == "" {
+		return resp, 0, fmt.Errorf("chapa: CHAPA_SECRET_KEY is not set")
+	}
+
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return resp, 0, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.BaseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return resp, 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.SecretKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return resp, 0, fmt.Errorf("chapa: %w", err)
+	}
+	defer res.Body.Close()
+
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return resp, res.StatusCode, fmt.Errorf("chapa: %s: unreadable response: %w", res.Status, err)
+	}
+	if res.StatusCode >= 300 || resp.Status != "success" {
+		return resp, res.StatusCode, fmt.Errorf("chapa: %s: %s", res.Status, resp.message())
+	}
+	return resp, res.StatusCode, nil
+}
+
+func (p *Chapa) Initialize(ctx context.Context, in InitializeRequest) (Checkout, error) {
+	payload := map[string]interface{}{
+		"amount":       fmt.Sprintf("%.2f", in.Amount),
+		"currency":     in.Currency,
+		"email":        in.Customer.Email,
+		"first_name":   in.Customer.FirstName,
+		"last_name":    in.Customer.LastName,
+		"tx_ref":       in.TxRef,
+		"callback_url": in.CallbackURL,
+		"return_url":   in.ReturnURL,
+		"customization": map[string]string{
+			"title":       in.Title,
+			"description": in.Description,
+		},
+	}
+	if in.Customer.Phone != "" {
+		payload["phone_number"] = in.Customer.Phone
+	}
+
+	resp, _, err := p.call(ctx, http.MethodPost, "/transaction/initialize", payload)
+	if err != nil {
+		return Checkout{}, err
+	}
+	var data struct {
+		CheckoutURL string `json:"checkout_url"`
+	}
+	if err := json.Unmarshal(resp.Data, &data); err != nil || data.CheckoutURL == "" {
+		return Checkout{}, fmt.Errorf("chapa: initialize returned no checkout_url")
+	}
+	return Checkout{CheckoutURL: data.CheckoutURL}, nil
+}
+
+func (p *Chapa) Verify(ctx context.Context, txRef string) (Transaction, error) {
+	resp, code, err := p.call(ctx, http.MethodGet, "/transaction/verify/"+url.PathEscape(txRef), nil)
+	if code == http.StatusNotFound {
+		return Transaction{}, ErrNotFound
+	}
+	if err != nil {
+		return Transaction{}, err
+	}
+	var data chapaTransaction
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		return Transaction{}, fmt.Errorf("chapa: unreadable verify data: %w", err)
+	}
+	if data.TxRef == "" {
+		data.TxRef = txRef
+	}
+	return Transaction{
+		TxRef:     data.TxRef,
+		Reference: data.Reference,
+		Status:    chapaStatus(data.Status),
+		Amount:    float64(data.Amount),
+		Currency:  strings.ToUpper(data.Currency),
+	}, nil
+}
+
+func (p *Chapa) Refund(ctx context.Context, in RefundRequest) (Refund, error) {
+	payload := map[string]interface{}{}
+	if in.Reason != "" {
+		payload["reason"] = in.Reason
+	}
+	if in.Amount > 0 {
+		payload["amount"] = fmt.Sprintf("%.2f", in.Amount)
+	}
+	if in.Reference != "" {
+		payload["reference"] = in.Reference
+	}
+	resp, _, err := p.call(ctx, http.MethodPost, "/refund/"+url.PathEscape(in.TxRef), payload)
+	if err != nil {
+		return Refund{}, err
+	}
+	var data struct {
+		Reference string `json:"reference"`
+		Status    string `json:"status"`
+	}
+	_ = json.Unmarshal(resp.Data, &data)
+	if data.Reference == "" {
+		data.Reference = in.Reference
+	}
+	status := chapaStatus(data.Status)
+	if data.Status == "" {
+		// The request was accepted; Chapa settles refunds later.
+		status = StatusPending
+	}
+	return Refund{Reference: data.Reference, Status: status}, nil
+}
+
+func (p *Chapa) ParseWebhook(header http.Header, body []byte) (WebhookEvent, error) {
+	if err := checkSignature(p.WebhookSecret, header, body); err != nil {
+		return WebhookEvent{}, err
+	}
+	return parseChapaWebhook(body)
+}
+
+func parseChapaWebhook(body []byte) (WebhookEvent, error) {
+	var data chapaTransaction
+	if err := json.Unmarshal(body, &data); err != nil || data.TxRef == "" {
+		return WebhookEvent{}, ErrInvalidWebhook
+	}
+	return WebhookEvent{
+		Type:      data.Event,
+		TxRef:     data.TxRef,
+		Reference: data.Reference,
+		Status:    chapaStatus(data.Status),
+		Amount:    float64(data.Amount),
+		Currency:  strings.ToUpper(data.Currency),
+	}, nil
+}
@@ -0,0 +1,117 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Fake is an in-process provider for development and tests. Checkouts send
+// the buyer straight to ReturnURL. With AutoPay every transaction is paid
+// as soon as it is opened; otherwise tests settle them with Pay or Fail.
+// Webhooks use the same JSON body as Chapa's, and are signature-checked
+// like Chapa's when WebhookSecret is set.
+type Fake struct {
+	AutoPay       bool
+	WebhookSecret string
+
+	mu           sync.Mutex
+	transactions map[string]*Transaction
+	refunds      []RefundRequest
+}
+
+func NewFake() *Fake {
+	return &Fake{transactions: map[string]*Transaction{}}
+}
+
+func (*Fake) Name() string { return "fake" }
+
+func (f *Fake) Initialize(ctx context.Context, in InitializeRequest) (Checkout, error) {
+	if in.TxRef == "" || in.Amount <= 0 {
+		return Checkout{}, fmt.Errorf("fake: tx_ref and a positive amount are required")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.transactions[in.TxRef]; ok {
+		return Checkout{}, fmt.Errorf("fake: duplicate tx_ref %q", in.TxRef)
+	}
+	status := StatusPending
+	if f.AutoPay {
+		status = StatusSuccess
+	}
+	f.transactions[in.TxRef] = &Transaction{
+		TxRef:     in.TxRef,
+		Reference: "FAKE-" + in.TxRef,
+		Status:    status,
+		Amount:    in.Amount,
+		Currency:  in.Currency,
+	}
+
+	checkout := in.ReturnURL
+	if checkout == "" {
+		checkout = "http://localhost:3000/"
+	}
+	sep := "?"
+	if strings.Contains(checkout, "?") {
+		sep = "&"
+	}
+	return Checkout{CheckoutURL: checkout + sep + "tx_ref=" + url.QueryEscape(in.TxRef)}, nil
+}
+
+func (f *Fake) Verify(ctx context.Context, txRef string) (Transaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.transactions[txRef]
+	if !ok {
+		return Transaction{}, ErrNotFound
+	}
+	return *t, nil
+}
+
+func (f *Fake) Refund(ctx context.Context, in RefundRequest) (Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.transactions[in.TxRef]
+	if !ok {
+		return Refund{}, ErrNotFound
+	}
+	if t.Status != StatusSuccess {
+		return Refund{}, fmt.Errorf("fake: %s is %s, not paid", in.TxRef, t.Status)
+	}
+	t.Status = StatusRefunded
+	f.refunds = append(f.refunds, in)
+	return Refund{Reference: in.Reference, Status: StatusRefunded}, nil
+}
+
+func (f *Fake) ParseWebhook(header http.Header, body []byte) (WebhookEvent, error) {
+	if f.WebhookSecret != "" {
+		if err := checkSignature(f.WebhookSecret, header, body); err != nil {
+			return WebhookEvent{}, err
+		}
+	}
+	return parseChapaWebhook(body)
+}
+
+// Pay marks txRef paid.
+func (f *Fake) Pay(txRef string) { f.settle(txRef, StatusSuccess) }
+
+// Fail marks txRef failed.
+func (f *Fake) Fail(txRef string) { f.settle(txRef, StatusFailed) }
+
+func (f *Fake) settle(txRef string, status Status) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if t, ok := f.transactions[txRef]; ok {
+		t.Status = status
+	}
+}
+
+// Refunds returns the refunds requested so far.
+func (f *Fake) Refunds() []RefundRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]RefundRequest(nil), f.refunds...)
+}
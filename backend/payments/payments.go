@@ -0,0 +1,156 @@
+// Package payments takes payments through a payment provider. Like the
+// mailer and the SMS sender, the provider is picked from configuration, so
+// development and tests can run against the in-process Fake instead of a
+// merchant account.
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Status is where a transaction or refund stands at the provider.
+type Status string
+
+const (
+	StatusPending  Status = "pending"
+	StatusSuccess  Status = "success"
+	StatusFailed   Status = "failed"
+	StatusRefunded Status = "refunded"
+)
+
+var (
+	// ErrNotFound means the provider has no transaction with that tx_ref.
+	ErrNotFound = errors.New("transaction not found")
+	// ErrInvalidWebhook means a webhook body could not be read.
+	ErrInvalidWebhook = errors.New("invalid webhook payload")
+	// ErrBadSignature means a webhook was not signed with our secret.
+	ErrBadSignature = errors.New("invalid webhook signature")
+)
+
+// Customer is who is paying.
+type Customer struct {
+	Email     string
+	FirstName string
+	LastName  string
+	Phone     string
+}
+
+// InitializeRequest opens a checkout. TxRef is our unique reference for the
+// payment; the provider reports it back in webhooks and verification.
+type InitializeRequest struct {
+	TxRef    string
+	Amount   float64
+	Currency string
+	Customer Customer
+	// CallbackURL is called by the provider when the payment settles;
+	// ReturnURL is where the buyer's browser goes afterwards.
+	CallbackURL string
+	ReturnURL   string
+	Title       string
+	Description string
+}
+
+// Checkout is an opened payment.
+type Checkout struct {
+	CheckoutURL string
+}
+
+// Transaction is the provider's record of a payment.
+type Transaction struct {
+	TxRef     string
+	Reference string
+	Status    Status
+	Amount    float64
+	Currency  string
+}
+
+// RefundRequest returns money for TxRef. A zero Amount refunds it in full.
+// Reference is our idempotency key for the refund.
+type RefundRequest struct {
+	TxRef     string
+	Amount    float64
+	Reason    string
+	Reference string
+}
+
+// Refund is the provider's answer to a RefundRequest.
+type Refund struct {
+	Reference string
+	Status    Status
+}
+
+// WebhookEvent is a parsed webhook delivery. Type is the provider's event
+// name, such as charge.success.
+type WebhookEvent struct {
+	Type      string
+	TxRef     string
+	Reference string
+	Status    Status
+	Amount    float64
+	Currency  string
+}
+
+// Provider is a payment gateway.
+type Provider interface {
+	// Name identifies the provider in stored records.
+	Name() string
+	Initialize(ctx context.Context, req InitializeRequest) (Checkout, error)
+	// Verify asks the provider for the current state of txRef.
+	Verify(ctx context.Context, txRef string) (Transaction, error)
+	Refund(ctx context.Context, req RefundRequest) (Refund, error)
+	// ParseWebhook checks a webhook delivery's signature and reads its raw
+	// body. Unsigned or mis-signed deliveries fail with ErrBadSignature.
+	ParseWebhook(header http.Header, body []byte) (WebhookEvent, error)
+}
+
+// Default is the provider handlers pay through. main replaces it with the
+// one returned by FromEnv.
+var Default Provider = NewFake()
+
+// FromEnv builds a Provider from PAYMENT_PROVIDER:
+//
+//	chapa  (default) CHAPA_SECRET_KEY, CHAPA_WEBHOOK_SECRET, CHAPA_BASE_URL
+//	fake   in-process payments, refused when GIN_MODE=release; they stay
+//	       pending unless PAYMENT_FAKE_AUTOPAY=true, and CHAPA_WEBHOOK_SECRET,
+//	       when set, is checked like Chapa's
+func FromEnv() (Provider, error) {
+	switch os.Getenv("PAYMENT_PROVIDER") {
+	case "", "chapa":
+		secret := os.Getenv("CHAPA_SECRET_KEY")
+		if secret == "" {
+			// The name used by older deployments.
+			secret = os.Getenv("CHAPA_SECRET")
+		}
+		return &Chapa{
+			BaseURL:       strings.TrimRight(envOr("CHAPA_BASE_URL", ChapaBaseURL), "/"),
+			SecretKey:     secret,
+			WebhookSecret: os.Getenv("CHAPA_WEBHOOK_SECRET"),
+			Client:        &http.Client{Timeout: 15 * time.Second},
+		}, nil
+	case "fake":
+		// The fake takes no money, so a production server must never
+		// hand out tickets through it.
+		if os.Getenv("GIN_MODE") == "release" {
+			return nil, fmt.Errorf("PAYMENT_PROVIDER=fake is not allowed with GIN_MODE=release")
+		}
+		f := NewFake()
+		f.AutoPay = os.Getenv("PAYMENT_FAKE_AUTOPAY") == "true"
+		f.WebhookSecret = os.Getenv("CHAPA_WEBHOOK_SECRET")
+		return f, nil
+	default:
+		return nil, fmt.Errorf("unknown PAYMENT_PROVIDER %q", os.Getenv("PAYMENT_PROVIDER"))
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
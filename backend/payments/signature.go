@@ -0,0 +1,36 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// SignatureHeaders are the headers Chapa signs webhook deliveries in.
+var SignatureHeaders = []string{"Chapa-Signature", "X-Chapa-Signature"}
+
+// Sign returns the hex HMAC-SHA256 of body under secret, as sent in the
+// signature headers.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkSignature accepts body when one of the signature headers carries its
+// HMAC under secret. An unset secret accepts nothing.
+func checkSignature(secret string, header http.Header, body []byte) error {
+	if secret == "" {
+		return ErrBadSignature
+	}
+	want := []byte(Sign(secret, body))
+	for _, name := range SignatureHeaders {
+		got := strings.ToLower(strings.TrimSpace(header.Get(name)))
+		if got != "" && hmac.Equal([]byte(got), want) {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
@@ -0,0 +1,279 @@
+package recurrence
+
+import (
+	"time"
+)
+
+// maxEmptyPeriods stops expansion of rules that can never match again,
+// such as BYMONTHDAY=31 with BYMONTH=2.
+const maxEmptyPeriods = 1000
+
+// Expand returns the recurrence set of r starting at dtstart, in order: dtstart
+// itself followed by every later instance up to and including end, at most
+// limit times. Instances keep dtstart's wall-clock time in dtstart's zone,
+// so they stay at the same local hour across daylight-saving changes.
+// COUNT counts dtstart as the first instance, as RFC 5545 requires.
+func (r *Rule) Expand(dtstart, end time.Time, limit int) []time.Time {
+	return r.Between(dtstart, dtstart, end, limit)
+}
+
+// Between is Expand restricted to the instances from from onwards. Earlier
+// instances still count toward COUNT but not toward limit, so a series that
+// has run for years keeps producing its upcoming instances.
+func (r *Rule) Between(dtstart, from, end time.Time, limit int) []time.Time {
+	if limit <= 0 || dtstart.After(end) {
+		return nil
+	}
+	var out []time.Time
+	if !dtstart.Before(from) {
+		out = append(out, dtstart)
+	}
+	if r.Count == 1 || len(out) >= limit {
+		return out
+	}
+
+	loc := dtstart.Location()
+	hour, min, sec := dtstart.Clock()
+	base := civil(dtstart)
+	seen, empty := 1, 0
+	for period := 0; empty < maxEmptyPeriods; period++ {
+		days := r.periodDays(base, period)
+		if len(days) == 0 {
+			empty++
+			continue
+		}
+		empty = 0
+		for _, d := range days {
+			t := time.Date(d.Year(), d.Month(), d.Day(), hour, min, sec, dtstart.Nanosecond(), loc)
+			if !t.After(dtstart) {
+				continue
+			}
+			if (!r.Until.IsZero() && t.After(r.Until)) || t.After(end) {
+				return out
+			}
+			seen++
+			if !t.Before(from) {
+				out = append(out, t)
+				if len(out) >= limit {
+					return out
+				}
+			}
+			if r.Count > 0 && seen >= r.Count {
+				return out
+			}
+		}
+	}
+	return out
+}
+
+// Exclude drops every time in ts that equals one of exdates.
+func Exclude(ts, exdates []time.Time) []time.Time {
+	if len(exdates) == 0 {
+		return ts
+	}
+	out := make([]time.Time, 0, len(ts))
+	for _, t := range ts {
+		skip := false
+		for _, ex := range exdates {
+			if t.Equal(ex) {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// civil is the calendar date of t at noon UTC, which makes day arithmetic
+// immune to the zone's daylight-saving jumps.
+func civil(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 12, 0, 0, 0, time.UTC)
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 12, 0, 0, 0, time.UTC).Day()
+}
+
+func monthDays(year int, month time.Month) []time.Time {
+	n := daysIn(year, month)
+	days := make([]time.Time, n)
+	for i := range days {
+		days[i] = time.Date(year, month, i+1, 12, 0, 0, 0, time.UTC)
+	}
+	return days
+}
+
+// periodDays returns the sorted candidate dates of the period-th period
+// after the one containing base.
+func (r *Rule) periodDays(base time.Time, period int) []time.Time {
+	step := period * r.Interval
+	var days []time.Time
+	switch r.Freq {
+	case Daily:
+		d := base.AddDate(0, 0, step)
+		if r.inMonths(d) && r.matchesMonthDay(d) && r.matchesWeekday(d) {
+			days = []time.Time{d}
+		}
+	case Weekly:
+		offset := (int(base.Weekday()) - int(r.WeekStart) + 7) % 7
+		start := base.AddDate(0, 0, step*7-offset)
+		for i := 0; i < 7; i++ {
+			d := start.AddDate(0, 0, i)
+			if !r.inMonths(d) {
+				continue
+			}
+			if len(r.ByDay) > 0 {
+				if r.matchesWeekday(d) {
+					days = append(days, d)
+				}
+			} else if d.Weekday() == base.Weekday() {
+				days = append(days, d)
+			}
+		}
+	case Monthly:
+		first := time.Date(base.Year(), base.Month()+time.Month(step), 1, 12, 0, 0, 0, time.UTC)
+		if r.inMonths(first) {
+			days = r.scopeDays(monthDays(first.Year(), first.Month()), base)
+		}
+	case Yearly:
+		year := base.Year() + step
+		switch {
+		case len(r.ByMonth) > 0:
+			for m := time.January; m <= time.December; m++ {
+				if r.inMonths(time.Date(year, m, 1, 12, 0, 0, 0, time.UTC)) {
+					days = append(days, r.scopeDays(monthDays(year, m), base)...)
+				}
+			}
+		case len(r.ByMonthDay) > 0:
+			for m := time.January; m <= time.December; m++ {
+				days = append(days, r.scopeDays(monthDays(year, m), base)...)
+			}
+		case len(r.ByDay) > 0:
+			var all []time.Time
+			for m := time.January; m <= time.December; m++ {
+				all = append(all, monthDays(year, m)...)
+			}
+			days = r.scopeDays(all, base)
+		default:
+			if base.Day() <= daysIn(year, base.Month()) {
+				days = []time.Time{time.Date(year, base.Month(), base.Day(), 12, 0, 0, 0, time.UTC)}
+			}
+		}
+	}
+	return r.applySetPos(days)
+}
+
+// scopeDays picks the dates of one month (or, for yearly BYDAY rules, one
+// year) that the BYMONTHDAY and BYDAY parts select. BYDAY ordinals count
+// within scope. Without either part the day of month of base is used.
+func (r *Rule) scopeDays(scope []time.Time, base time.Time) []time.Time {
+	if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+		for _, d := range scope {
+			if d.Day() == base.Day() && (r.Freq == Monthly || d.Month() == base.Month() || len(r.ByMonth) > 0) {
+				return []time.Time{d}
+			}
+		}
+		return nil
+	}
+
+	selected := map[time.Time]bool{}
+	if len(r.ByDay) > 0 {
+		for _, wd := range r.ByDay {
+			var same []time.Time
+			for _, d := range scope {
+				if d.Weekday() == wd.Day {
+					same = append(same, d)
+				}
+			}
+			switch {
+			case wd.N == 0:
+				for _, d := range same {
+					selected[d] = true
+				}
+			case wd.N > 0 && wd.N <= len(same):
+				selected[same[wd.N-1]] = true
+			case wd.N < 0 && -wd.N <= len(same):
+				selected[same[len(same)+wd.N]] = true
+			}
+		}
+	}
+
+	var out []time.Time
+	for _, d := range scope {
+		if len(r.ByDay) > 0 && !selected[d] {
+			continue
+		}
+		if !r.matchesMonthDay(d) {
+			continue
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+func (r *Rule) inMonths(d time.Time) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, m := range r.ByMonth {
+		if d.Month() == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesMonthDay(d time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	n := daysIn(d.Year(), d.Month())
+	for _, md := range r.ByMonthDay {
+		if d.Day() == md || (md < 0 && d.Day() == n+md+1) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesWeekday checks BYDAY as a plain filter, for rules where ordinals
+// are not allowed.
+func (r *Rule) matchesWeekday(d time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, wd := range r.ByDay {
+		if d.Weekday() == wd.Day {
+			return true
+		}
+	}
+	return false
+}
+
+// applySetPos keeps only the BYSETPOS positions of the period's dates.
+func (r *Rule) applySetPos(days []time.Time) []time.Time {
+	sortTimes(days)
+	if len(r.BySetPos) == 0 || len(days) == 0 {
+		return days
+	}
+	picked := map[int]bool{}
+	for _, pos := range r.BySetPos {
+		i := pos - 1
+		if pos < 0 {
+			i = len(days) + pos
+		}
+		if i >= 0 && i < len(days) {
+			picked[i] = true
+		}
+	}
+	var out []time.Time
+	for i, d := range days {
+		if picked[i] {
+			out = append(out, d)
+		}
+	}
+	return out
+}
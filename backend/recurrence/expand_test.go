@@ -0,0 +1,201 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+const layout = "2006-01-02T15:04-07:00"
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func formatAll(ts []time.Time) []string {
+	out := make([]string, len(ts))
+	for i, t := range ts {
+		out[i] = t.Format(layout)
+	}
+	return out
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestExpand(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	far := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		rule    string
+		dtstart time.Time
+		exdates []time.Time
+		want    []string
+	}{
+		{
+			name:    "count",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC),
+			want:    []string{"2024-01-01T09:00+00:00", "2024-01-02T09:00+00:00", "2024-01-03T09:00+00:00"},
+		},
+		{
+			name:    "floating until is read in the event's zone",
+			rule:    "FREQ=DAILY;UNTIL=20240103T090000",
+			dtstart: time.Date(2024, 1, 1, 9, 0, 0, 0, ny),
+			want:    []string{"2024-01-01T09:00-05:00", "2024-01-02T09:00-05:00", "2024-01-03T09:00-05:00"},
+		},
+		{
+			name:    "utc until is inclusive",
+			rule:    "FREQ=DAILY;UNTIL=20240103T140000Z",
+			dtstart: time.Date(2024, 1, 1, 9, 0, 0, 0, ny),
+			want:    []string{"2024-01-01T09:00-05:00", "2024-01-02T09:00-05:00", "2024-01-03T09:00-05:00"},
+		},
+		{
+			name:    "utc until before the last instance",
+			rule:    "FREQ=DAILY;UNTIL=20240103T135959Z",
+			dtstart: time.Date(2024, 1, 1, 9, 0, 0, 0, ny),
+			want:    []string{"2024-01-01T09:00-05:00", "2024-01-02T09:00-05:00"},
+		},
+		{
+			name:    "first saturday",
+			rule:    "FREQ=MONTHLY;BYDAY=1SA;COUNT=3",
+			dtstart: time.Date(2024, 1, 6, 18, 0, 0, 0, time.UTC),
+			want:    []string{"2024-01-06T18:00+00:00", "2024-02-03T18:00+00:00", "2024-03-02T18:00+00:00"},
+		},
+		{
+			name:    "last friday",
+			rule:    "FREQ=MONTHLY;BYDAY=-1FR;COUNT=3",
+			dtstart: time.Date(2024, 1, 26, 18, 0, 0, 0, time.UTC),
+			want:    []string{"2024-01-26T18:00+00:00", "2024-02-23T18:00+00:00", "2024-03-29T18:00+00:00"},
+		},
+		{
+			name:    "last weekday of the month",
+			rule:    "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1;COUNT=3",
+			dtstart: time.Date(2024, 1, 31, 17, 0, 0, 0, time.UTC),
+			want:    []string{"2024-01-31T17:00+00:00", "2024-02-29T17:00+00:00", "2024-03-29T17:00+00:00"},
+		},
+		{
+			name:    "last day of the month",
+			rule:    "FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=4",
+			dtstart: time.Date(2024, 1, 31, 20, 0, 0, 0, time.UTC),
+			want: []string{
+				"2024-01-31T20:00+00:00", "2024-02-29T20:00+00:00",
+				"2024-03-31T20:00+00:00", "2024-04-30T20:00+00:00",
+			},
+		},
+		{
+			name:    "the 31st skips shorter months",
+			rule:    "FREQ=MONTHLY;COUNT=4",
+			dtstart: time.Date(2024, 1, 31, 20, 0, 0, 0, time.UTC),
+			want: []string{
+				"2024-01-31T20:00+00:00", "2024-03-31T20:00+00:00",
+				"2024-05-31T20:00+00:00", "2024-07-31T20:00+00:00",
+			},
+		},
+		{
+			name:    "weeks starting monday",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=MO",
+			dtstart: time.Date(1997, 8, 5, 9, 0, 0, 0, time.UTC),
+			want: []string{
+				"1997-08-05T09:00+00:00", "1997-08-10T09:00+00:00",
+				"1997-08-19T09:00+00:00", "1997-08-24T09:00+00:00",
+			},
+		},
+		{
+			name:    "weeks starting sunday",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=SU",
+			dtstart: time.Date(1997, 8, 5, 9, 0, 0, 0, time.UTC),
+			want: []string{
+				"1997-08-05T09:00+00:00", "1997-08-17T09:00+00:00",
+				"1997-08-19T09:00+00:00", "1997-08-31T09:00+00:00",
+			},
+		},
+		{
+			name:    "excluded instances still count",
+			rule:    "FREQ=WEEKLY;COUNT=4",
+			dtstart: time.Date(2024, 1, 1, 19, 0, 0, 0, time.UTC),
+			exdates: []time.Time{time.Date(2024, 1, 8, 19, 0, 0, 0, time.UTC)},
+			want:    []string{"2024-01-01T19:00+00:00", "2024-01-15T19:00+00:00", "2024-01-22T19:00+00:00"},
+		},
+		{
+			name:    "local time is kept across a daylight-saving change",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: time.Date(2024, 3, 9, 9, 0, 0, 0, ny),
+			want:    []string{"2024-03-09T09:00-05:00", "2024-03-10T09:00-04:00", "2024-03-11T09:00-04:00"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseInLocation(tt.rule, tt.dtstart.Location())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := formatAll(Exclude(r.Expand(tt.dtstart, far, 1000), tt.exdates))
+			if !equalStrings(got, tt.want) {
+				t.Errorf("Expand(%s) = %v, want %v", tt.rule, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBetweenLongRunningSeries(t *testing.T) {
+	dtstart := time.Date(2023, 1, 1, 18, 0, 0, 0, time.UTC)
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		rule        string
+		limit       int
+		n           int
+		first, last string
+	}{
+		{
+			name: "open-ended", rule: "FREQ=DAILY", limit: 1000, n: 365,
+			first: "2026-01-01T18:00+00:00", last: "2026-12-31T18:00+00:00",
+		},
+		{
+			name: "limit applies to the window only", rule: "FREQ=DAILY", limit: 10, n: 10,
+			first: "2026-01-01T18:00+00:00", last: "2026-01-10T18:00+00:00",
+		},
+		{
+			// Instances 1097 to 1200 fall inside the window.
+			name: "count includes earlier instances", rule: "FREQ=DAILY;COUNT=1200", limit: 1000, n: 104,
+			first: "2026-01-01T18:00+00:00", last: "2026-04-14T18:00+00:00",
+		},
+		{
+			name: "count used up before the window", rule: "FREQ=DAILY;COUNT=1000", limit: 1000, n: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := formatAll(r.Between(dtstart, from, end, tt.limit))
+			if len(got) != tt.n {
+				t.Fatalf("Between returned %d instances, want %d", len(got), tt.n)
+			}
+			if tt.n > 0 && (got[0] != tt.first || got[len(got)-1] != tt.last) {
+				t.Errorf("Between = %s .. %s, want %s .. %s", got[0], got[len(got)-1], tt.first, tt.last)
+			}
+		})
+	}
+}
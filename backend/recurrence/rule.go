@@ -0,0 +1,278 @@
+// Package recurrence parses iCalendar (RFC 5545) recurrence rules and
+// expands them into occurrence start times. It has no database or HTTP
+// dependencies, so rules can be checked in isolation.
+//
+// The supported subset covers what organizers need for repeating events:
+// FREQ=DAILY/WEEKLY/MONTHLY/YEARLY with INTERVAL, COUNT or UNTIL, BYDAY
+// (with ordinals such as 1SA or -1FR for monthly and yearly rules),
+// BYMONTHDAY, BYMONTH, BYSETPOS and WKST. Sub-daily frequencies, BYHOUR,
+// BYMINUTE, BYSECOND, BYWEEKNO and BYYEARDAY are rejected.
+package recurrence
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the FREQ rule part.
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// WeekdayNum is one BYDAY entry. N is the ordinal within the month or year
+// (1 is the first, -1 the last); 0 means every such weekday.
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+// Rule is a parsed RRULE.
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []time.Month
+	BySetPos   []int
+	WeekStart  time.Weekday
+}
+
+var dayCodes = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+func dayCode(d time.Weekday) string {
+	return strings.ToUpper(d.String()[:2])
+}
+
+// untilLayout is how UNTIL is written back: always in UTC.
+const untilLayout = "20060102T150405Z"
+
+// Parse reads an RRULE value, with or without the "RRULE:" prefix. A
+// floating UNTIL (no trailing Z) is read in UTC; use ParseInLocation to read
+// it in the event's zone.
+func Parse(s string) (*Rule, error) {
+	return ParseInLocation(s, time.UTC)
+}
+
+// ParseInLocation is Parse with floating UNTIL values read in loc.
+func ParseInLocation(s string, loc *time.Location) (*Rule, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "RRULE:"), "rrule:")
+	if s == "" {
+		return nil, fmt.Errorf("empty recurrence rule")
+	}
+
+	r := &Rule{Interval: 1, WeekStart: time.Monday}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		key = strings.ToUpper(strings.TrimSpace(key))
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("malformed rule part %q", part)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("%s is given twice", key)
+		}
+		seen[key] = true
+
+		var err error
+		switch key {
+		case "FREQ":
+			switch f := Frequency(value); f {
+			case Daily, Weekly, Monthly, Yearly:
+				r.Freq = f
+			case "SECONDLY", "MINUTELY", "HOURLY":
+				return nil, fmt.Errorf("FREQ=%s is not supported", value)
+			default:
+				return nil, fmt.Errorf("unknown FREQ %q", value)
+			}
+		case "INTERVAL":
+			r.Interval, err = parseInt(value, 1, 1000)
+		case "COUNT":
+			r.Count, err = parseInt(value, 1, 10000)
+		case "UNTIL":
+			r.Until, err = parseUntil(value, loc)
+		case "BYDAY":
+			for _, v := range strings.Split(value, ",") {
+				wd, err := parseWeekdayNum(v)
+				if err != nil {
+					return nil, err
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseIntList(value, -31, 31)
+		case "BYMONTH":
+			var months []int
+			months, err = parseIntList(value, 1, 12)
+			for _, m := range months {
+				r.ByMonth = append(r.ByMonth, time.Month(m))
+			}
+		case "BYSETPOS":
+			r.BySetPos, err = parseIntList(value, -366, 366)
+		case "WKST":
+			d, ok := dayCodes[value]
+			if !ok {
+				return nil, fmt.Errorf("unknown WKST %q", value)
+			}
+			r.WeekStart = d
+		case "BYHOUR", "BYMINUTE", "BYSECOND", "BYWEEKNO", "BYYEARDAY":
+			return nil, fmt.Errorf("%s is not supported", key)
+		default:
+			return nil, fmt.Errorf("unknown rule part %s", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	if r.Freq == "" {
+		return nil, fmt.Errorf("FREQ is required")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return nil, fmt.Errorf("COUNT and UNTIL cannot both be given")
+	}
+	for _, wd := range r.ByDay {
+		if wd.N != 0 && r.Freq != Monthly && r.Freq != Yearly {
+			return nil, fmt.Errorf("BYDAY ordinals are only allowed with FREQ=MONTHLY or YEARLY")
+		}
+	}
+	if len(r.BySetPos) > 0 && len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 {
+		return nil, fmt.Errorf("BYSETPOS needs BYDAY or BYMONTHDAY")
+	}
+	if r.Freq == Weekly && len(r.ByMonthDay) > 0 {
+		return nil, fmt.Errorf("BYMONTHDAY is not allowed with FREQ=WEEKLY")
+	}
+	return r, nil
+}
+
+func parseInt(s string, min, max int) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(s, "+"))
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%q must be a number from %d to %d", s, min, max)
+	}
+	return n, nil
+}
+
+func parseIntList(s string, min, max int) ([]int, error) {
+	var out []int
+	for _, v := range strings.Split(s, ",") {
+		n, err := parseInt(v, min, max)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("0 is not a valid position")
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func parseWeekdayNum(s string) (WeekdayNum, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+	}
+	day, ok := dayCodes[s[len(s)-2:]]
+	if !ok {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+	}
+	wd := WeekdayNum{Day: day}
+	if prefix := s[:len(s)-2]; prefix != "" {
+		n, err := parseInt(prefix, -53, 53)
+		if err != nil || n == 0 {
+			return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+		}
+		wd.N = n
+	}
+	return wd, nil
+}
+
+func parseUntil(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(untilLayout, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("20060102T150405", s, loc); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("20060102", s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a date or date-time", s)
+	}
+	// A date-only UNTIL includes the whole day.
+	return t.AddDate(0, 0, 1).Add(-time.Second), nil
+}
+
+// String writes the rule back in canonical RRULE form, without the prefix.
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(untilLayout))
+	}
+	if len(r.ByMonth) > 0 {
+		var ms []string
+		for _, m := range r.ByMonth {
+			ms = append(ms, strconv.Itoa(int(m)))
+		}
+		parts = append(parts, "BYMONTH="+strings.Join(ms, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		parts = append(parts, "BYMONTHDAY="+joinInts(r.ByMonthDay))
+	}
+	if len(r.ByDay) > 0 {
+		var ds []string
+		for _, wd := range r.ByDay {
+			code := dayCode(wd.Day)
+			if wd.N != 0 {
+				code = strconv.Itoa(wd.N) + code
+			}
+			ds = append(ds, code)
+		}
+		parts = append(parts, "BYDAY="+strings.Join(ds, ","))
+	}
+	if len(r.BySetPos) > 0 {
+		parts = append(parts, "BYSETPOS="+joinInts(r.BySetPos))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+dayCode(r.WeekStart))
+	}
+	return strings.Join(parts, ";")
+}
+
+func joinInts(ns []int) string {
+	var s []string
+	for _, n := range ns {
+		s = append(s, strconv.Itoa(n))
+	}
+	return strings.Join(s, ",")
+}
+
+// Finite reports whether the rule ends by itself through COUNT or UNTIL.
+func (r *Rule) Finite() bool {
+	return r.Count > 0 || !r.Until.IsZero()
+}
+
+// sortTimes orders ts ascending in place.
+func sortTimes(ts []time.Time) {
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Before(ts[j]) })
+}
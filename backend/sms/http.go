@@ -0,0 +1,50 @@
+package sms
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// HTTPSender posts messages as JSON to an SMS gateway:
+//
+//	{"to": "+2519...", "message": "...", "from": "<SenderID>"}
+//
+// with the token as a bearer credential. Any 2xx response counts as sent.
+type HTTPSender struct {
+	URL      string
+	Token    string
+	SenderID string
+	Client   *http.Client
+}
+
+func (s HTTPSender) SendSMS(to, body string) error {
+	payload := map[string]string{"to": to, "message": body}
+	if s.SenderID != "" {
+		payload["from"] = s.SenderID
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sms gateway: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("sms gateway: %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package sms
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogSender prints messages to the server log instead of sending them.
+type LogSender struct{}
+
+func (LogSender) SendSMS(to, body string) error {
+	log.Printf("📱 SMS to %s: %s", to, body)
+	return nil
+}
+
+// FileSender appends messages to a file so tests can read codes back.
+type FileSender struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (s *FileSender) SendSMS(to, body string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), to, body)
+	return err
+}
@@ -0,0 +1,51 @@
+// Package sms sends text messages such as login codes. Like the mailer, the
+// implementation is picked from configuration so development needs no
+// gateway account.
+package sms
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// SMSSender delivers body to the E.164 number to.
+type SMSSender interface {
+	SendSMS(to, body string) error
+}
+
+// Default is the sender handlers use. main replaces it with the one returned
+// by FromEnv.
+var Default SMSSender = LogSender{}
+
+// FromEnv builds an SMSSender from SMS_SENDER:
+//
+//	http  posts to SMS_GATEWAY_URL with SMS_GATEWAY_TOKEN and SMS_SENDER_ID
+//	file  appends every message to SMS_FILE
+//	log   (default) prints messages to the server log
+func FromEnv() (SMSSender, error) {
+	switch os.Getenv("SMS_SENDER") {
+	case "http":
+		s := HTTPSender{
+			URL:      os.Getenv("SMS_GATEWAY_URL"),
+			Token:    os.Getenv("SMS_GATEWAY_TOKEN"),
+			SenderID: os.Getenv("SMS_SENDER_ID"),
+			Client:   &http.Client{Timeout: 10 * time.Second},
+		}
+		if s.URL == "" {
+			return nil, fmt.Errorf("SMS_GATEWAY_URL is required for SMS_SENDER=http")
+		}
+		return s, nil
+	case "file":
+		path := os.Getenv("SMS_FILE")
+		if path == "" {
+			path = "./sms.log"
+		}
+		return &FileSender{Path: path}, nil
+	case "", "log":
+		return LogSender{}, nil
+	default:
+		return nil, fmt.Errorf("unknown SMS_SENDER %q", os.Getenv("SMS_SENDER"))
+	}
+}
@@ -0,0 +1,28 @@
+package tickets
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"local-event-backend/utils"
+)
+
+// RunReaper releases expired holds every interval until ctx is done, so
+// seats abandoned at checkout go back on sale.
+func RunReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := ReleaseExpired(utils.DB); err != nil {
+			log.Println("❌ Releasing expired holds failed:", err)
+		} else if n > 0 {
+			log.Printf("⌛ Released %d expired ticket holds\n", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Package tickets reserves ticket inventory. A purchase first takes a hold:
+// a pending ticket_sales row whose seats are already counted against the
+// ticket type (and, for recurring events, the occurrence). The hold becomes
+// a sale when payment completes, or is released when payment fails or its
+// time runs out.
+//
+// Inventory is taken with conditional increments of the sold counters,
+// which Postgres serializes on the row, so concurrent buyers can never
+// oversell the last seats.
+package tickets
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"time"
+)
+
+// DefaultHoldTTL is how long a hold lasts when TICKET_HOLD_TTL is unset.
+const DefaultHoldTTL = 15 * time.Minute
+
+// Sale statuses.
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusExpired   = "expired"
+)
+
+var (
+	// ErrSoldOut means the ticket type has too few tickets left.
+	ErrSoldOut = errors.New("not enough tickets left")
+	// ErrDateSoldOut means the chosen occurrence has too few seats left,
+	// or is no longer on sale.
+	ErrDateSoldOut = errors.New("not enough tickets left for this date")
+)
+
+// HoldTTL reads TICKET_HOLD_TTL, a Go duration such as "10m".
+func HoldTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("TICKET_HOLD_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return DefaultHoldTTL
+}
+
+// Hold is an order to reserve. Prices come from the ticket type.
+type Hold struct {
+	EventID      int
+	TicketTypeID int
+	OccurrenceID *int
+	UserID       int
+	Quantity     int
+	UnitPrice    float64
+	Amount       float64
+	Currency     string
+}
+
+// Reserve takes h's tickets within tx and records the order, returning its
+// ticket_sales ID and status. Paid orders are held as pending until HoldTTL
+// from now; free ones complete at once and get their tickets.
+func Reserve(tx *sql.Tx, h Hold) (int, string, error) {
+	res, err := tx.Exec(`
+		UPDATE ticket_types SET sold = sold + $2
+		WHERE id = $1 AND (quantity IS NULL OR sold + $2 <= quantity)`,
+		h.TicketTypeID, h.Quantity)
+	if err != nil {
+		return 0, "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, "", ErrSoldOut
+	}
+
+	if h.OccurrenceID != nil {
+		res, err := tx.Exec(`
+			UPDATE event_occurrences SET tickets_sold = tickets_sold + $3
+			WHERE id = $1 AND event_id = $2 AND status = 'scheduled' AND starts_at > NOW()
+			  AND (capacity IS NULL OR tickets_sold + $3 <= capacity)`,
+			*h.OccurrenceID, h.EventID, h.Quantity)
+		if err != nil {
+			return 0, "", err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return 0, "", ErrDateSoldOut
+		}
+	}
+
+	status := StatusPending
+	var expires *time.Time
+	if h.Amount == 0 {
+		status = StatusCompleted
+	} else {
+		t := time.Now().Add(HoldTTL())
+		expires = &t
+	}
+
+	var saleID int
+	err = tx.QueryRow(`
+		INSERT INTO ticket_sales
+		(event_id, ticket_type_id, user_id, quantity, unit_price, amount, currency, status, created_at, occurrence_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), $9, $10)
+		RETURNING id`,
+		h.EventID, h.TicketTypeID, h.UserID, h.Quantity, h.UnitPrice, h.Amount, h.Currency, status, h.OccurrenceID, expires,
+	).Scan(&saleID)
+	if err != nil {
+		return 0, "", err
+	}
+	if status == StatusCompleted {
+		err = Issue(tx, saleID)
+	}
+	return saleID, status, err
+}
+
+// Issue writes one ticket per seat of a completed sale.
+func Issue(tx *sql.Tx, saleID int) error {
+	_, err := tx.Exec(`
+		INSERT INTO tickets (user_id, event_id, occurrence_id, ticket_type_id, ticket_number, status)
+		SELECT s.user_id, s.event_id, s.occurrence_id, s.ticket_type_id, 'TKT-' || s.id || '-' || n, 'active'
+		FROM ticket_sales s, generate_series(1, GREATEST(s.quantity, 1)) n
+		WHERE s.id = $1`, saleID)
+	return err
+}
+
+// rowQueryer is satisfied by *sql.DB and *sql.Tx.
+type rowQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// releaseWhere moves the pending sales matching where (over ticket_sales)
+// to status and returns their seats, all in one statement so a hold is
+// never released twice. It reports how many sales were released.
+func releaseWhere(db rowQueryer, status, where string, args ...interface{}) (int, error) {
+	var n int
+	err := db.QueryRow(`
+		WITH released AS (
+			UPDATE ticket_sales SET status = $1
+			WHERE status = 'pending' AND `+where+`
+			RETURNING ticket_type_id, occurrence_id, quantity
+		), by_type AS (
+			UPDATE ticket_types t SET sold = GREATEST(t.sold - r.n, 0)
+			FROM (SELECT ticket_type_id, SUM(quantity) AS n FROM released GROUP BY ticket_type_id) r
+			WHERE t.id = r.ticket_type_id
+		), by_occurrence AS (
+			UPDATE event_occurrences o SET tickets_sold = GREATEST(o.tickets_sold - r.n, 0)
+			FROM (SELECT occurrence_id, SUM(quantity) AS n FROM released GROUP BY occurrence_id) r
+			WHERE o.id = r.occurrence_id
+		)
+		SELECT COUNT(*) FROM released`,
+		append([]interface{}{status}, args...)...,
+	).Scan(&n)
+	return n, err
+}
+
+// Release ends a pending hold with status (failed or expired) and returns
+// its seats. It reports false when the sale was no longer pending.
+func Release(db rowQueryer, saleID int, status string) (bool, error) {
+	n, err := releaseWhere(db, status, `id = $2`, saleID)
+	return n > 0, err
+}
+
+// ReleaseExpired expires every hold past its expires_at.
+func ReleaseExpired(db rowQueryer) (int, error) {
+	return releaseWhere(db, StatusExpired, `expires_at < NOW()`)
+}
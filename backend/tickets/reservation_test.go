@@ -0,0 +1,157 @@
+package tickets
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+
+	"local-event-backend/testdb"
+)
+
+// reserveConcurrently has n buyers each reserve h at the same time, one
+// transaction apiece, and counts the outcomes by error (nil for success).
+func reserveConcurrently(t *testing.T, db *sql.DB, n int, h Hold) map[error]int {
+	t.Helper()
+	var (
+		mu      sync.Mutex
+		results = map[error]int{}
+		start   = make(chan struct{})
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(user int) {
+			defer wg.Done()
+			<-start
+			hold := h
+			hold.UserID = user
+			err := func() error {
+				tx, err := db.Begin()
+				if err != nil {
+					return err
+				}
+				defer tx.Rollback()
+				if _, _, err := Reserve(tx, hold); err != nil {
+					return err
+				}
+				return tx.Commit()
+			}()
+			mu.Lock()
+			results[err]++
+			mu.Unlock()
+		}(i + 1)
+	}
+	close(start)
+	wg.Wait()
+	return results
+}
+
+func TestReserveDoesNotOversell(t *testing.T) {
+	db := testdb.Open(t)
+	quantity, buyers := 5, 40
+	eventID := testdb.Event(t, db)
+	typeID := testdb.TicketType(t, db, eventID, 10, &quantity)
+
+	results := reserveConcurrently(t, db, buyers, Hold{
+		EventID: eventID, TicketTypeID: typeID, Quantity: 1, UnitPrice: 10, Amount: 10, Currency: "ETB",
+	})
+	if results[nil] != quantity || results[ErrSoldOut] != buyers-quantity || len(results) != 2 {
+		t.Fatalf("results = %v, want %d successes and %d ErrSoldOut", results, quantity, buyers-quantity)
+	}
+
+	var sold, sales int
+	db.QueryRow(`SELECT sold FROM ticket_types WHERE id = $1`, typeID).Scan(&sold)
+	db.QueryRow(`SELECT COUNT(*) FROM ticket_sales WHERE status = 'pending'`).Scan(&sales)
+	if sold != quantity || sales != quantity {
+		t.Fatalf("sold = %d, pending sales = %d, want %d", sold, sales, quantity)
+	}
+}
+
+func TestReserveRespectsOccurrenceCapacity(t *testing.T) {
+	db := testdb.Open(t)
+	const capacity, buyers = 3, 30
+	eventID := testdb.Event(t, db)
+	typeID := testdb.TicketType(t, db, eventID, 10, nil)
+
+	var occurrenceID int
+	err := db.QueryRow(`
+		INSERT INTO event_occurrences (event_id, original_starts_at, starts_at, ends_at, capacity)
+		VALUES ($1, NOW() + interval '1 day', NOW() + interval '1 day', NOW() + interval '1 day 2 hours', $2)
+		RETURNING id`, eventID, capacity).Scan(&occurrenceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := reserveConcurrently(t, db, buyers, Hold{
+		EventID: eventID, TicketTypeID: typeID, OccurrenceID: &occurrenceID, Quantity: 1, UnitPrice: 10, Amount: 10, Currency: "ETB",
+	})
+	if results[nil] != capacity || results[ErrDateSoldOut] != buyers-capacity || len(results) != 2 {
+		t.Fatalf("results = %v, want %d successes and %d ErrDateSoldOut", results, capacity, buyers-capacity)
+	}
+
+	// Losers' ticket type increments must have rolled back with them.
+	var typeSold, occurrenceSold int
+	db.QueryRow(`SELECT sold FROM ticket_types WHERE id = $1`, typeID).Scan(&typeSold)
+	db.QueryRow(`SELECT tickets_sold FROM event_occurrences WHERE id = $1`, occurrenceID).Scan(&occurrenceSold)
+	if typeSold != capacity || occurrenceSold != capacity {
+		t.Fatalf("ticket type sold = %d, occurrence sold = %d, want %d", typeSold, occurrenceSold, capacity)
+	}
+}
+
+func TestReleaseExpiredReleasesOnce(t *testing.T) {
+	db := testdb.Open(t)
+	const holds, reapers = 4, 8
+	quantity := 10
+	eventID := testdb.Event(t, db)
+	typeID := testdb.TicketType(t, db, eventID, 10, &quantity)
+
+	results := reserveConcurrently(t, db, holds, Hold{
+		EventID: eventID, TicketTypeID: typeID, Quantity: 2, UnitPrice: 10, Amount: 20, Currency: "ETB",
+	})
+	if results[nil] != holds {
+		t.Fatalf("results = %v, want %d holds", results, holds)
+	}
+	if _, err := db.Exec(`UPDATE ticket_sales SET expires_at = NOW() - interval '1 minute'`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Several reapers racing must release each hold exactly once.
+	var (
+		mu       sync.Mutex
+		released int
+		wg       sync.WaitGroup
+		start    = make(chan struct{})
+	)
+	for i := 0; i < reapers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			n, err := ReleaseExpired(db)
+			if err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			released += n
+			mu.Unlock()
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if released != holds {
+		t.Fatalf("released %d holds, want %d", released, holds)
+	}
+	var sold, expired int
+	db.QueryRow(`SELECT sold FROM ticket_types WHERE id = $1`, typeID).Scan(&sold)
+	db.QueryRow(`SELECT COUNT(*) FROM ticket_sales WHERE status = $1`, StatusExpired).Scan(&expired)
+	if sold != 0 || expired != holds {
+		t.Fatalf("sold = %d, expired = %d, want 0 and %d", sold, expired, holds)
+	}
+
+	var saleID int
+	db.QueryRow(`SELECT MIN(id) FROM ticket_sales`).Scan(&saleID)
+	if ok, err := Release(db, saleID, StatusFailed); err != nil || ok {
+		t.Fatalf("Release of an expired hold = %v, %v; want false", ok, err)
+	}
+}
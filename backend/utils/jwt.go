@@ -2,25 +2,57 @@ package utils
 
 import (
 	"fmt"
+	"os"
 	"time"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var JwtSecret = []byte("supersecretkeylocaldev1234567891")
+// AccessTokenTTL is how long an access token stays valid. Clients keep their
+// session alive with the refresh token instead of a long-lived JWT.
+const AccessTokenTTL = 15 * time.Minute
+
+// JwtIssuer is the "iss" claim put on every token and required when verifying.
+func JwtIssuer() string {
+	if iss := os.Getenv("JWT_ISSUER"); iss != "" {
+		return iss
+	}
+	return "local-event-backend"
+}
+
+// JwtAudience is the "aud" claim put on every token and required when verifying.
+func JwtAudience() string {
+	if aud := os.Getenv("JWT_AUDIENCE"); aud != "" {
+		return aud
+	}
+	return "minab"
+}
 
-// GenerateToken returns a Hasura-compatible JWT signed with JwtSecret.
-func GenerateToken(userID int) (string, error) {
+// GenerateToken returns a Hasura-compatible JWT signed with the active key
+// from Keys, carrying its kid so verifiers can pick the right public key.
+// sessionID ties the token to a user_sessions family so it can be revoked,
+// and is passed on as x-hasura-session-id so action requests name it too;
+// roles become x-hasura-allowed-roles with defaultRole as the default.
+func GenerateToken(userID int, sessionID string, roles []string, defaultRole string) (string, error) {
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"sub": fmt.Sprintf("%d", userID),
-		"iat": time.Now().Add(-time.Minute * 5).Unix(),
-		"exp": time.Now().Add(time.Hour * 72).Unix(),
+		"sid": sessionID,
+		"iss": JwtIssuer(),
+		"aud": JwtAudience(),
+		"iat": now.Add(-time.Minute * 5).Unix(),
+		"nbf": now.Add(-time.Minute * 5).Unix(),
+		"exp": now.Add(AccessTokenTTL).Unix(),
 		"https://hasura.io/jwt/claims": map[string]interface{}{
-			"x-hasura-allowed-roles": []string{"user"},
-			"x-hasura-default-role":  "user",
+			"x-hasura-allowed-roles": roles,
+			"x-hasura-default-role":  defaultRole,
 			"x-hasura-user-id":       fmt.Sprintf("%d", userID),
+			"x-hasura-session-id":    sessionID,
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(JwtSecret)
-}
\ No newline at end of file
+	key := Keys.Active()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is one entry of the key set. Verify-only keys (the previous key
+// during a rotation) have no Private part.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// KeySet holds the key new tokens are signed with plus every key tokens are
+// still accepted from, indexed by kid.
+type KeySet struct {
+	active *SigningKey
+	byID   map[string]*SigningKey
+	ids    []string // load order, active key first
+}
+
+// Global key set, loaded once by LoadKeys
+var Keys *KeySet
+
+// LoadKeys reads the signing configuration:
+//
+//	JWT_SIGNING_KEY_FILE  PEM private key (RSA or Ed25519) used to sign
+//	JWT_SIGNING_KEY_ID    its kid
+//	JWT_VERIFY_KEYS       comma-separated kid=path of keys still accepted,
+//	                      e.g. the previous key while a rotation rolls out
+//
+// Without JWT_SIGNING_KEY_FILE an ephemeral RSA key is generated so local
+// development works out of the box; tokens then do not survive a restart.
+func LoadKeys() (*KeySet, error) {
+	ks := &KeySet{byID: map[string]*SigningKey{}}
+
+	if path := os.Getenv("JWT_SIGNING_KEY_FILE"); path != "" {
+		kid := os.Getenv("JWT_SIGNING_KEY_ID")
+		if kid == "" {
+			return nil, fmt.Errorf("JWT_SIGNING_KEY_ID is required with JWT_SIGNING_KEY_FILE")
+		}
+		key, err := readKeyFile(kid, path)
+		if err != nil {
+			return nil, err
+		}
+		if key.Private == nil {
+			return nil, fmt.Errorf("%s: signing key must be a private key", path)
+		}
+		ks.active = key
+	} else {
+		log.Println("⚠️ JWT_SIGNING_KEY_FILE not set, generating an ephemeral signing key")
+		priv, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		ks.active = &SigningKey{ID: "dev-ephemeral", Method: jwt.SigningMethodRS256, Private: priv, Public: priv.Public()}
+	}
+	ks.byID[ks.active.ID] = ks.active
+	ks.ids = append(ks.ids, ks.active.ID)
+
+	for _, entry := range strings.Split(os.Getenv("JWT_VERIFY_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, path, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("JWT_VERIFY_KEYS entry %q must be kid=path", entry)
+		}
+		key, err := readKeyFile(kid, path)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := ks.byID[kid]; dup {
+			return nil, fmt.Errorf("duplicate key id %q", kid)
+		}
+		ks.byID[kid] = &SigningKey{ID: kid, Method: key.Method, Public: key.Public}
+		ks.ids = append(ks.ids, kid)
+	}
+
+	Keys = ks
+	return ks, nil
+}
+
+// Active returns the key new tokens are signed with.
+func (ks *KeySet) Active() *SigningKey {
+	return ks.active
+}
+
+// Lookup returns the key for kid if tokens signed with it are still accepted.
+func (ks *KeySet) Lookup(kid string) (*SigningKey, bool) {
+	key, ok := ks.byID[kid]
+	return key, ok
+}
+
+// Algorithms lists the JWT "alg" values of every accepted key.
+func (ks *KeySet) Algorithms() []string {
+	seen := map[string]bool{}
+	var algs []string
+	for _, id := range ks.ids {
+		if alg := ks.byID[id].Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// JWKS renders the public half of every accepted key as a JSON Web Key Set.
+func (ks *KeySet) JWKS() map[string]interface{} {
+	keys := []map[string]string{}
+	for _, id := range ks.ids {
+		key := ks.byID[id]
+		jwk := map[string]string{
+			"kid": key.ID,
+			"use": "sig",
+			"alg": key.Method.Alg(),
+		}
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		keys = append(keys, jwk)
+	}
+	return map[string]interface{}{"keys": keys}
+}
+
+func readKeyFile(kid, path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key %s: %w", kid, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s: no PEM block in %s", kid, path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("key %s: unsupported PEM type %q", kid, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", kid, err)
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, Private: k, Public: k.Public()}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, Private: k, Public: k.Public()}, nil
+	case *rsa.PublicKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, Public: k}, nil
+	case ed25519.PublicKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, Public: k}, nil
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %T", kid, parsed)
+	}
+}
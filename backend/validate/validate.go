@@ -0,0 +1,135 @@
+// Package validate normalizes and checks user-supplied fields. Every check
+// returns an *Error whose Code is stable so clients can localize messages.
+package validate
+
+import (
+	"net/mail"
+	"strings"
+	"unicode"
+)
+
+// Error is a single rejected value.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Email trims and lowercases s and checks it is a bare address with a
+// dotted domain. It returns the normalized address.
+func Email(s string) (string, error) {
+	email := strings.ToLower(strings.TrimSpace(s))
+	if email == "" {
+		return "", &Error{Code: "required", Message: "Email is required"}
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return "", &Error{Code: "invalid_email", Message: "Enter a valid email address"}
+	}
+	at := strings.LastIndex(email, "@")
+	domain := email[at+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", &Error{Code: "invalid_email", Message: "Enter a valid email address"}
+	}
+	return email, nil
+}
+
+const (
+	minPasswordLength = 8
+	// bcrypt ignores everything after 72 bytes.
+	maxPasswordLength = 72
+)
+
+// Password enforces the password policy: 8 to 72 bytes, at least one letter
+// and one digit, and not the same as the account's email.
+func Password(password, email string) error {
+	if password == "" {
+		return &Error{Code: "required", Message: "Password is required"}
+	}
+	if len(password) < minPasswordLength {
+		return &Error{Code: "password_too_short", Message: "Password must be at least 8 characters"}
+	}
+	if len(password) > maxPasswordLength {
+		return &Error{Code: "password_too_long", Message: "Password must be at most 72 characters"}
+	}
+
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return &Error{Code: "password_too_weak", Message: "Password must contain both letters and numbers"}
+	}
+	if email != "" && strings.EqualFold(password, email) {
+		return &Error{Code: "password_too_weak", Message: "Password must not be your email address"}
+	}
+	return nil
+}
+
+// Phone normalizes s to E.164. Ethiopian numbers may be written locally
+// (0911 23 45 67, 911234567) or with the country code (251..., +251...);
+// other countries must include a leading "+". An empty s is returned as is.
+func Phone(s string) (string, error) {
+	invalid := &Error{Code: "invalid_phone", Message: "Enter a valid phone number, e.g. 0911234567 or +251911234567"}
+
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(s) {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return "", invalid
+		}
+	}
+	digits := b.String()
+	if digits == "" {
+		return "", nil
+	}
+
+	international := strings.HasPrefix(digits, "+")
+	digits = strings.TrimPrefix(digits, "+")
+
+	switch {
+	case !international && len(digits) == 10 && digits[0] == '0':
+		digits = "251" + digits[1:]
+	case !international && len(digits) == 9:
+		digits = "251" + digits
+	case !international && !strings.HasPrefix(digits, "251"):
+		return "", invalid
+	}
+
+	if strings.HasPrefix(digits, "251") {
+		// Ethiopian mobile numbers: 9XXXXXXXX (Ethio Telecom) or 7XXXXXXXX (Safaricom).
+		local := digits[3:]
+		if len(local) != 9 || (local[0] != '9' && local[0] != '7') {
+			return "", invalid
+		}
+	}
+	if len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+		return "", invalid
+	}
+	return "+" + digits, nil
+}
+
+// Languages are the interface languages a user can pick.
+var Languages = []string{"en", "am", "om", "ti", "so"}
+
+// Language lowercases s and checks it is one of Languages.
+func Language(s string) (string, error) {
+	lang := strings.ToLower(strings.TrimSpace(s))
+	for _, l := range Languages {
+		if lang == l {
+			return lang, nil
+		}
+	}
+	return "", &Error{Code: "unsupported_language", Message: "Choose one of: " + strings.Join(Languages, ", ")}
+}